@@ -0,0 +1,143 @@
+// Command catalog imports and exports the product catalog from the command
+// line, using the same validation as the /products/import endpoint.
+//
+//	catalog import [-format csv|ndjson] [-dry-run] products.csv
+//	catalog export [-format csv|ndjson] [-o products.csv]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"oldsouqs-backend/config"
+	"oldsouqs-backend/controllers"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  catalog import [-format csv|ndjson] [-dry-run] FILE")
+	fmt.Fprintln(os.Stderr, "  catalog export [-format csv|ndjson] [-o FILE]")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using system environment variables")
+	}
+
+	switch os.Args[1] {
+	case "import":
+		runImport(os.Args[2:])
+	case "export":
+		runExport(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func runImport(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "csv or ndjson (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate and report without writing")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromPath(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	db := connect()
+
+	report, err := controllers.ImportCatalog(context.Background(), db, file, *format, *dryRun, "")
+	if err != nil {
+		log.Fatal("Import failed: ", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	format := flags.String("format", "", "csv or ndjson (default: from -o, else csv)")
+	output := flags.String("o", "", "file to write (default: stdout)")
+	flags.Parse(args)
+	if flags.NArg() != 0 {
+		usage()
+	}
+
+	if *format == "" {
+		*format = controllers.CatalogCSV
+		if *output != "" {
+			*format = formatFromPath(*output)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	db := connect()
+
+	if err := controllers.ExportCatalog(context.Background(), db, w, *format); err != nil {
+		log.Fatal("Export failed: ", err)
+	}
+}
+
+// connect opens the database. ConnectDB reports its progress on stdout,
+// which here carries the report or the export, so that goes to stderr.
+func connect() *mongo.Database {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	db, err := config.ConnectDB()
+	os.Stdout = stdout
+
+	if err != nil {
+		log.Fatal("Database connection failed:", err)
+	}
+	return db
+}
+
+// formatFromPath guesses the format from a file name, defaulting to CSV.
+func formatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ndjson", ".jsonl", ".json":
+		return controllers.CatalogNDJSON
+	default:
+		return controllers.CatalogCSV
+	}
+}
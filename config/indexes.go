@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the application relies on. Creating an
+// index that already exists is a no-op, so this is safe to run on every start.
+// It runs after the migrations, which fix the data some indexes need.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	indexes := map[string][]mongo.IndexModel{
+		"refresh_tokens": {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}}},
+			// Expired refresh tokens are removed by MongoDB
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"user_tokens": {
+			{Keys: bson.D{{Key: "tokenHash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "purpose", Value: 1}}},
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+		"login_attempts": {
+			// Failure counters reset a day after the last failed attempt
+			{Keys: bson.D{{Key: "lastFailureAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(24 * 60 * 60)},
+		},
+		"audit_events": {
+			{Keys: bson.D{{Key: "type", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+		},
+		"products": {
+			{Keys: bson.D{{Key: "sku", Value: 1}}},
+			{Keys: bson.D{{Key: "variants.sku", Value: 1}}},
+			{Keys: bson.D{{Key: "tag", Value: 1}}},
+			{Keys: bson.D{{Key: "price", Value: 1}}},
+			{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "deletedAt", Value: 1}}},
+			// Full-text search; searchText holds the Arabic-normalized fields
+			{
+				Keys: bson.D{
+					{Key: "title", Value: "text"},
+					{Key: "titleAr", Value: "text"},
+					{Key: "description", Value: "text"},
+					{Key: "descriptionAr", Value: "text"},
+					{Key: "sku", Value: "text"},
+					{Key: "tag", Value: "text"},
+					{Key: "searchText", Value: "text"},
+				},
+				Options: options.Index().SetName("product_text").SetWeights(bson.M{
+					"title":         10,
+					"titleAr":       10,
+					"searchText":    8,
+					"sku":           6,
+					"tag":           4,
+					"description":   2,
+					"descriptionAr": 2,
+				}),
+			},
+		},
+		"orders": {
+			// Public order numbers; the order-numbers migration removed legacy duplicates
+			{Keys: bson.D{{Key: "orderId", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "creationDate", Value: -1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}, {Key: "creationDate", Value: -1}}},
+		},
+		"coupons": {
+			{Keys: bson.D{{Key: "code", Value: 1}}, Options: options.Index().SetUnique(true)},
+		},
+		"coupon_redemptions": {
+			{Keys: bson.D{{Key: "couponId", Value: 1}}},
+		},
+		"stock_movements": {
+			{Keys: bson.D{{Key: "productId", Value: 1}, {Key: "createdAt", Value: -1}}},
+			{Keys: bson.D{{Key: "orderId", Value: 1}}},
+		},
+		"revoked_tokens": {
+			{Keys: bson.D{{Key: "expiresAt", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		},
+	}
+
+	// Each index is created on its own so one that cannot be built, such as a
+	// unique index over duplicate data, doesn't keep the others from being created
+	var errs []error
+	for name, models := range indexes {
+		for _, model := range models {
+			if _, err := db.Collection(name).Indexes().CreateOne(ctx, model); err != nil {
+				errs = append(errs, fmt.Errorf("error creating index %v on %s: %w", model.Keys, name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
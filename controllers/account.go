@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+// appBaseURL is the frontend address used to build links in emails.
+func appBaseURL() string {
+	if base := os.Getenv("APP_BASE_URL"); base != "" {
+		return strings.TrimSuffix(base, "/")
+	}
+	return "http://localhost:3000"
+}
+
+// createUserToken stores a new single-use token for userID and returns the
+// raw token. Older unused tokens with the same purpose are invalidated.
+func createUserToken(ctx context.Context, db *mongo.Database, userID primitive.ObjectID, purpose string, ttl time.Duration) (string, error) {
+	tokens := db.Collection("user_tokens")
+
+	now := time.Now()
+	_, err := tokens.UpdateMany(ctx,
+		bson.M{"userId": userID, "purpose": purpose, "usedAt": nil},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to invalidate old tokens: %w", err)
+	}
+
+	token, hash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := models.UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := tokens.InsertOne(ctx, record); err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// consumeUserToken marks a valid, unused token as used and returns it.
+// Each token can only be consumed once.
+func consumeUserToken(ctx context.Context, db *mongo.Database, token string, purpose string) (models.UserToken, error) {
+	now := time.Now()
+	filter := bson.M{
+		"tokenHash": utils.HashToken(token),
+		"purpose":   purpose,
+		"usedAt":    nil,
+		"expiresAt": bson.M{"$gt": now},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var record models.UserToken
+	err := db.Collection("user_tokens").FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"usedAt": now}}, opts).Decode(&record)
+	return record, err
+}
+
+// sendVerificationEmail mails a verification link to user.
+func sendVerificationEmail(ctx context.Context, db *mongo.Database, mailer utils.Mailer, user models.User) error {
+	token, err := createUserToken(ctx, db, user.ID, models.TokenPurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appBaseURL() + "/verify-email?token=" + token
+	body := fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening this link:\n%s\n\nThe link is valid for 48 hours.", user.FirstName, link)
+	return mailer.Send(user.Email, "Verify your Old Souqs email", body)
+}
+
+// ForgotPasswordHandler handles POST /auth/forgot-password. The response is
+// the same whether or not the email belongs to an account. Repeated requests
+// for an email or from an IP are refused for a while with 429.
+func ForgotPasswordHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database, mailer utils.Mailer) {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Email) == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	// Requests are throttled per email and per IP whether or not an account
+	// exists, so neither the limit nor its absence gives accounts away
+	ip := clientIP(r)
+	accountKey, ipKey := resetAccountKey(body.Email), resetIPKey(ip)
+	remaining, err := lockoutRemaining(ctx, db, accountKey, ipKey)
+	if err != nil {
+		http.Error(w, "Could not start password reset", http.StatusInternalServerError)
+		return
+	}
+	if remaining > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		http.Error(w, "Too many password reset requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+	countAttempt(ctx, db, accountKey, maxResetRequestsPerEmail)
+	countAttempt(ctx, db, ipKey, maxResetRequestsPerIP)
+
+	var user models.User
+	err = db.Collection("users").FindOne(ctx, bson.M{"email": strings.TrimSpace(body.Email)}).Decode(&user)
+	if err == nil {
+		token, err := createUserToken(ctx, db, user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			http.Error(w, "Could not start password reset", http.StatusInternalServerError)
+			fmt.Println("Error creating reset token:", err)
+			return
+		}
+
+		link := appBaseURL() + "/reset-password?token=" + token
+		message := fmt.Sprintf("Hello %s,\n\nUse this link to choose a new password:\n%s\n\nThe link is valid for one hour. If you did not ask for this, you can ignore this email.", user.FirstName, link)
+		if err := mailer.Send(user.Email, "Reset your Old Souqs password", message); err != nil {
+			fmt.Println("Error sending reset email:", err)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		http.Error(w, "Could not start password reset", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account exists for this email, a reset link has been sent",
+	})
+}
+
+// ResetPasswordHandler handles POST /auth/reset-password. A successful reset
+// ends every existing session of the account.
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	// Check the password before using up the token
+	if valError := validatePassword(body.Password); valError != "" {
+		http.Error(w, valError, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	record, err := consumeUserToken(ctx, db, body.Token, models.TokenPurposePasswordReset)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Could not hash password", http.StatusInternalServerError)
+		return
+	}
+
+	// Receiving the reset email also proves the address is the user's
+	update := bson.M{"$set": bson.M{"password": string(hashedPassword), "emailVerified": true}}
+	res, err := db.Collection("users").UpdateOne(ctx, bson.M{"_id": record.UserID}, update)
+	if err != nil || res.MatchedCount == 0 {
+		http.Error(w, "Could not update password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := revokeUserSessions(ctx, db, record.UserID); err != nil {
+		fmt.Println("Error revoking sessions after password reset:", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Password has been reset"})
+}
+
+// VerifyEmailHandler handles POST /auth/verify-email.
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	record, err := consumeUserToken(ctx, db, body.Token, models.TokenPurposeEmailVerification)
+	if err != nil {
+		http.Error(w, "Invalid or expired token", http.StatusBadRequest)
+		return
+	}
+
+	res, err := db.Collection("users").UpdateOne(ctx, bson.M{"_id": record.UserID}, bson.M{"$set": bson.M{"emailVerified": true}})
+	if err != nil || res.MatchedCount == 0 {
+		http.Error(w, "Could not verify email", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Email verified"})
+}
+
+// ResendVerificationHandler handles POST /auth/verify-email/resend for the
+// logged-in user.
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database, mailer utils.Mailer) {
+	userID, err := primitive.ObjectIDFromHex(utils.UserIDFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if user.EmailVerified {
+		http.Error(w, "Email already verified", http.StatusConflict)
+		return
+	}
+
+	if err := sendVerificationEmail(ctx, db, mailer, user); err != nil {
+		http.Error(w, "Could not send verification email", http.StatusInternalServerError)
+		fmt.Println("Error sending verification email:", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Verification email sent"})
+}
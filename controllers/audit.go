@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordAuditEvent adds an entry to the audit trail. Failures are logged
+// rather than returned so auditing never breaks the request being audited.
+func recordAuditEvent(ctx context.Context, db *mongo.Database, event models.AuditEvent) {
+	event.ID = primitive.NewObjectID()
+	event.CreatedAt = time.Now()
+
+	if _, err := db.Collection("audit_events").InsertOne(ctx, event); err != nil {
+		fmt.Printf("Warning: failed to record audit event %s: %v\n", event.Type, err)
+	}
+}
+
+// GetAuditEvents handles GET /admin/audit-events?type=&email=&limit=
+func GetAuditEvents(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	query := r.URL.Query()
+
+	filter := bson.M{}
+	if eventType := query.Get("type"); eventType != "" {
+		filter["type"] = eventType
+	}
+	if email := query.Get("email"); email != "" {
+		filter["email"] = strings.ToLower(strings.TrimSpace(email))
+	}
+
+	limit := int64(100)
+	if raw := query.Get("limit"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || parsed <= 0 || parsed > 500 {
+			http.Error(w, "limit must be between 1 and 500", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}).SetLimit(limit)
+	cursor, err := db.Collection("audit_events").Find(ctx, filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to fetch audit events", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.AuditEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		http.Error(w, "Error reading audit events", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
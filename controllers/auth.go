@@ -4,7 +4,9 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"unicode"
 
@@ -12,17 +14,37 @@ import (
 	"oldsouqs-backend/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func validate(user models.User) string {
 	// Trim spaces
 	email := strings.TrimSpace(user.Email)
-	password := strings.TrimSpace(user.Password)
 	phone := strings.TrimSpace(user.PhoneNumber)
 
+	if valError := validatePassword(user.Password); valError != "" {
+		return valError
+	}
+
+	// Simple email format check
+	if !strings.Contains(email, "@") || !strings.Contains(email, ".") {
+		return "Invalid email format"
+	}
+
+	// Simple phone number check (Lebanese format only)
+	if !(strings.HasPrefix(phone, "+961") || strings.HasPrefix(phone, "00961")) || len(phone) != 12 {
+		return "Phone number must start with +961 or 00961 followed by 8 digits"
+	}
+
+	return ""
+}
+
+// validatePassword applies the password-strength rules used at signup.
+func validatePassword(password string) string {
+	password = strings.TrimSpace(password)
+
 	// Basic password length check
 	if len(password) < 10 {
 		return "Password must contain at least 10 characters"
@@ -54,21 +76,10 @@ func validate(user models.User) string {
 		return "Password must contain at least one special character"
 	}
 
-	// Simple email format check
-	if !strings.Contains(email, "@") || !strings.Contains(email, ".") {
-		return "Invalid email format"
-	}
-
-	// Simple phone number check (Lebanese format only)
-	if !(strings.HasPrefix(phone, "+961") || strings.HasPrefix(phone, "00961")) || len(phone) != 12 {
-		return "Phone number must start with +961 or 00961 followed by 8 digits"
-	}
-
 	return ""
 }
 
-
-func SignupHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+func SignupHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database, mailer utils.Mailer) {
 	var user models.User
 
 	// Decode request body
@@ -86,6 +97,10 @@ func SignupHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
+	// Self-registered accounts are always unverified customers
+	user.Role = models.RoleCustomer
+	user.EmailVerified = false
+
 	// Get users collection
 	userCollection := db.Collection("users")
 
@@ -118,24 +133,32 @@ func SignupHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	// Get the inserted user ID and attach it to the user struct
 	user.ID = result.InsertedID.(primitive.ObjectID)
 
+	// A failed verification email shouldn't fail the signup; it can be resent
+	if err := sendVerificationEmail(r.Context(), db, mailer, user); err != nil {
+		fmt.Println("Error sending verification email:", err)
+	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(user)
+	// Generate JWT and refresh tokens
+	token, refreshToken, err := issueSession(r.Context(), db, user.ID)
 	if err != nil {
 		http.Error(w, "Could not generate token", http.StatusInternalServerError)
 		fmt.Println("Error generating token:", err)
 		return
 	}
 
-	// Respond with token and userId
+	// Respond with tokens and userId
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
-		"message": "User created successfully",
-		"token":   token,
-		"userId":  user.ID.Hex(),
+		"message":      "User created successfully",
+		"token":        token,
+		"refreshToken": refreshToken,
+		"userId":       user.ID.Hex(),
 	})
 }
 
+// dummyPasswordHash is compared against when the login email is unknown.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
 func LoginHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	var userInput models.User
 	// Decode the login data (email and password)
@@ -145,35 +168,90 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
+	ip := clientIP(r)
+	accountKey := accountAttemptKey(userInput.Email)
+	ipKey := ipAttemptKey(ip)
+
+	// Refuse early while the account or the caller's IP is locked out
+	remaining, err := lockoutRemaining(r.Context(), db, accountKey, ipKey)
+	if err != nil {
+		http.Error(w, "Could not verify login", http.StatusInternalServerError)
+		return
+	}
+	if remaining > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
 	// Fetch user from the database by email
 	var user models.User
 	collection := db.Collection("users")
 	err = collection.FindOne(r.Context(), bson.M{"email": userInput.Email}).Decode(&user)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	if err != nil && err != mongo.ErrNoDocuments {
+		http.Error(w, "Could not verify login", http.StatusInternalServerError)
 		return
 	}
 
-	// Compare the provided password with the stored hashed password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userInput.Password))
-	if err != nil {
-		http.Error(w, "Wrong Password", http.StatusUnauthorized)
+	// Compare the provided password with the stored hashed password. Unknown
+	// emails are checked against a dummy hash so both cases take as long.
+	hash := dummyPasswordHash
+	if err == nil {
+		hash = []byte(user.Password)
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(userInput.Password)) != nil || err != nil {
+		recordLoginFailure(r.Context(), db, accountKey, maxAccountFailures, userInput.Email, ip)
+		recordLoginFailure(r.Context(), db, ipKey, maxIPFailures, userInput.Email, ip)
+		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Generate JWT token (optional, if using JWT for session management)
-	token, err := utils.GenerateJWT(user)
+	if err := clearLoginFailures(r.Context(), db, accountKey, userInput.Email, ip, "", "successful_login"); err != nil {
+		fmt.Println("Warning: failed to reset login failures:", err)
+	}
+
+	// Generate JWT and refresh tokens
+	token, refreshToken, err := issueSession(r.Context(), db, user.ID)
 	if err != nil {
 		http.Error(w, "Could not generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Return success with the JWT token
+	// Return success with the JWT and refresh tokens
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]string{
-		"message": "Login successful",
-		"token":   token,
-		"userId": user.ID.Hex(),
+		"message":      "Login successful",
+		"token":        token,
+		"refreshToken": refreshToken,
+		"userId":       user.ID.Hex(),
 	}
 	json.NewEncoder(w).Encode(response)
-}
\ No newline at end of file
+}
+
+// resolveUserID returns the user a cart, wishlist or order request acts on.
+// That is the caller from the token, unless an admin explicitly names another
+// user in requested. Anyone else asking for a different user gets a 403.
+func resolveUserID(w http.ResponseWriter, r *http.Request, requested string) (string, bool) {
+	callerID := utils.UserIDFromContext(r.Context())
+	if callerID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return "", false
+	}
+
+	if requested == "" || requested == callerID {
+		return callerID, true
+	}
+
+	if utils.RoleFromContext(r.Context()) == models.RoleAdmin {
+		return requested, true
+	}
+
+	http.Error(w, "Forbidden", http.StatusForbidden)
+	return "", false
+}
+
+// isStaffRequest reports whether the caller may manage other users' orders.
+func isStaffRequest(r *http.Request) bool {
+	role := utils.RoleFromContext(r.Context())
+	return role == models.RoleAdmin || role == models.RoleStaff
+}
@@ -22,9 +22,8 @@ func AddToCart(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "Missing userId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -49,9 +48,8 @@ func AddToCart(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 
 // 📦 Get cart items for a specific user
 func GetCart(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "Missing userId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -74,10 +72,13 @@ func GetCart(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 func UpdateCartItem(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	vars := mux.Vars(r)
 	productID := vars["productId"]
-	userID := r.URL.Query().Get("userId")
+	if productID == "" {
+		http.Error(w, "Missing productId", http.StatusBadRequest)
+		return
+	}
 
-	if userID == "" || productID == "" {
-		http.Error(w, "Missing userId or productId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -94,7 +95,12 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request, db *mongo.Database)
 
 	cartCollection := db.Collection("carts")
 
-	filter := bson.M{"userId": userID, "items.productId": productID}
+	line := bson.M{"productId": productID}
+	if variantID := r.URL.Query().Get("variantId"); variantID != "" {
+		line["variantId"] = variantID
+	}
+
+	filter := bson.M{"userId": userID, "items": bson.M{"$elemMatch": line}}
 	update := bson.M{"$set": bson.M{"items.$.quantity": body.Quantity}}
 
 	_, err := cartCollection.UpdateOne(ctx, filter, update)
@@ -111,10 +117,13 @@ func UpdateCartItem(w http.ResponseWriter, r *http.Request, db *mongo.Database)
 func RemoveFromCart(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	vars := mux.Vars(r)
 	productID := vars["productId"]
-	userID := r.URL.Query().Get("userId")
+	if productID == "" {
+		http.Error(w, "Missing productId", http.StatusBadRequest)
+		return
+	}
 
-	if userID == "" || productID == "" {
-		http.Error(w, "Missing userId or productId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -123,8 +132,14 @@ func RemoveFromCart(w http.ResponseWriter, r *http.Request, db *mongo.Database)
 
 	cartCollection := db.Collection("carts")
 
+	// Without a variantId every line of the product is removed
+	line := bson.M{"productId": productID}
+	if variantID := r.URL.Query().Get("variantId"); variantID != "" {
+		line["variantId"] = variantID
+	}
+
 	filter := bson.M{"userId": userID}
-	update := bson.M{"$pull": bson.M{"items": bson.M{"productId": productID}}}
+	update := bson.M{"$pull": bson.M{"items": line}}
 
 	_, err := cartCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
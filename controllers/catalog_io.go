@@ -0,0 +1,529 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Formats accepted by the catalog import and export.
+const (
+	CatalogCSV    = "csv"
+	CatalogNDJSON = "ndjson"
+)
+
+// catalogCSVHeader is the column layout of CSV exports. Imports accept the
+// columns in any order. Tags are separated by "|". Variants can only be
+// imported and exported as NDJSON.
+var catalogCSVHeader = []string{"sku", "title", "titleAr", "description", "descriptionAr", "price", "stock", "tags", "image"}
+
+// maxImportSize caps the size of an uploaded import file.
+const maxImportSize = 10 << 20
+
+// ImportRowResult reports what happened to one row of an import.
+type ImportRowResult struct {
+	Row    int    `json:"row"` // Line number in the file
+	Sku    string `json:"sku,omitempty"`
+	Action string `json:"action,omitempty"` // "create" or "update"
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportReport summarizes an import. In a dry run nothing is written and
+// the report shows what the import would do.
+type ImportReport struct {
+	DryRun  bool              `json:"dryRun"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Failed  int               `json:"failed"`
+	Rows    []ImportRowResult `json:"rows"`
+}
+
+// catalogRow is one decoded import row, or why it could not be decoded.
+type catalogRow struct {
+	line    int
+	product models.Product
+	fields  map[string]bool // Product fields the row has a column or key for, by JSON name
+	err     error
+}
+
+// readCatalogRows decodes every row of an import file. Only an unreadable
+// file is an error; a malformed row is reported on the row.
+func readCatalogRows(r io.Reader, format string) ([]catalogRow, error) {
+	switch format {
+	case CatalogCSV:
+		return readCatalogCSV(r)
+	case CatalogNDJSON:
+		return readCatalogNDJSON(r)
+	default:
+		return nil, fmt.Errorf("format must be %s or %s", CatalogCSV, CatalogNDJSON)
+	}
+}
+
+func readCatalogCSV(r io.Reader) ([]catalogRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	known := map[string]bool{}
+	for _, name := range catalogCSVHeader {
+		known[name] = true
+	}
+	columns := map[string]int{}
+	fields := map[string]bool{}
+	for i, name := range header {
+		// Spreadsheet programs may start the file with a byte order mark
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		if !known[name] {
+			return nil, fmt.Errorf("unknown CSV column %q", name)
+		}
+		columns[name] = i
+		if name == "tags" {
+			name = "tag"
+		}
+		fields[name] = true
+	}
+	if _, ok := columns["sku"]; !ok {
+		return nil, fmt.Errorf("CSV header must include sku")
+	}
+
+	var rows []catalogRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) || parseErr.Err != csv.ErrFieldCount {
+				return nil, fmt.Errorf("failed to read CSV: %w", err)
+			}
+			rows = append(rows, catalogRow{line: line, err: fmt.Errorf("expected %d columns, got %d", len(header), len(record))})
+			continue
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := catalogRow{line: line, fields: fields}
+		row.product = models.Product{
+			Sku:           field("sku"),
+			Title:         field("title"),
+			TitleAr:       field("titleAr"),
+			Description:   field("description"),
+			DescriptionAr: field("descriptionAr"),
+			Image:         field("image"),
+		}
+		for _, tag := range strings.Split(field("tags"), "|") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				row.product.Tag = append(row.product.Tag, tag)
+			}
+		}
+		if raw := field("price"); raw != "" {
+			if row.product.Price, err = strconv.ParseFloat(raw, 64); err != nil {
+				row.err = fmt.Errorf("price must be a number")
+			}
+		}
+		if raw := field("stock"); raw != "" && row.err == nil {
+			stock, err := strconv.ParseInt(raw, 10, 32)
+			if err != nil || stock < 0 {
+				row.err = fmt.Errorf("stock must be a non-negative whole number")
+			}
+			row.product.Stock = int32(stock)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func readCatalogNDJSON(r io.Reader) ([]catalogRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+
+	var rows []catalogRow
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		row := catalogRow{line: line, fields: map[string]bool{}}
+		var keys map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(text), &keys); err != nil {
+			row.err = fmt.Errorf("invalid JSON: %v", err)
+		} else if err := json.Unmarshal([]byte(text), &row.product); err != nil {
+			row.err = fmt.Errorf("invalid JSON: %v", err)
+		}
+		for key := range keys {
+			row.fields[key] = true
+		}
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read NDJSON: %w", err)
+	}
+	return rows, nil
+}
+
+// ImportCatalog validates every row of an import file like CreateProduct
+// does and upserts the products by SKU, moving them between tag collections
+// as their tags change. Rows that fail are reported and skipped; the other
+// rows are still imported. An update only changes the fields the row has a
+// column or key for, and rows without variants keep the variants of an
+// existing product.
+func ImportCatalog(ctx context.Context, db *mongo.Database, r io.Reader, format string, dryRun bool, actorID string) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun, Rows: []ImportRowResult{}}
+
+	rows, err := readCatalogRows(r, format)
+	if err != nil {
+		return report, err
+	}
+
+	seen := map[string]int{}
+	for _, row := range rows {
+		result := ImportRowResult{Row: row.line, Sku: strings.TrimSpace(row.product.Sku)}
+
+		err := row.err
+		if err == nil {
+			result.Action, err = importCatalogRow(ctx, db, row.product, row.fields, seen, row.line, dryRun, actorID)
+		}
+		if err != nil {
+			result.Action = ""
+			result.Error = err.Error()
+			report.Failed++
+		} else if result.Action == "create" {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+		report.Rows = append(report.Rows, result)
+	}
+
+	if !dryRun && report.Created+report.Updated > 0 {
+		catalogSuggestions.refresh(db)
+	}
+	return report, nil
+}
+
+// importedFields are the product fields an import row can update, by JSON
+// name. The SKU identifies the product and is never changed.
+var importedFields = []string{"title", "titleAr", "description", "descriptionAr", "price", "stock", "tag", "image", "variants"}
+
+// mergeImportedFields copies the fields named in fields from row onto
+// existing and returns the result.
+func mergeImportedFields(existing models.Product, row models.Product, fields map[string]bool) models.Product {
+	merged := existing
+	for _, field := range importedFields {
+		if !fields[field] {
+			continue
+		}
+		switch field {
+		case "title":
+			merged.Title = row.Title
+		case "titleAr":
+			merged.TitleAr = row.TitleAr
+		case "description":
+			merged.Description = row.Description
+		case "descriptionAr":
+			merged.DescriptionAr = row.DescriptionAr
+		case "price":
+			merged.Price = row.Price
+		case "stock":
+			merged.Stock = row.Stock
+		case "tag":
+			merged.Tag = row.Tag
+		case "image":
+			merged.Image = row.Image
+		case "variants":
+			// An empty list keeps the variants; imports never remove them
+			if len(row.Variants) > 0 {
+				merged.Variants = row.Variants
+			}
+		}
+	}
+	return merged
+}
+
+// importCatalogRow validates and, unless dryRun, writes a single product.
+// fields names the product fields the row has a column or key for. seen
+// holds the SKUs of earlier rows of the same file.
+func importCatalogRow(ctx context.Context, db *mongo.Database, product models.Product, fields map[string]bool, seen map[string]int, line int, dryRun bool, actorID string) (string, error) {
+	product.ID = primitive.NilObjectID
+	product.Sku = strings.TrimSpace(product.Sku)
+
+	products := db.Collection("products")
+
+	var existing models.Product
+	action := "update"
+	err := products.FindOne(ctx, bson.M{"sku": product.Sku}).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		action = "create"
+	} else if err != nil {
+		return "", fmt.Errorf("failed to look up SKU: %v", err)
+	}
+
+	if action == "update" {
+		product = mergeImportedFields(existing, product, fields)
+	} else if err := prepareProductImages(&product); err != nil {
+		return "", err
+	}
+	if product.Tag == nil {
+		product.Tag = []string{}
+	}
+	if err := prepareVariants(&product); err != nil {
+		return "", err
+	}
+	if err := validateProduct(product); err != nil {
+		return "", err
+	}
+
+	skus := productSkus(product)
+	for _, sku := range skus {
+		if first, dup := seen[sku]; dup {
+			return "", fmt.Errorf("SKU %s is already used on line %d", sku, first)
+		}
+	}
+	for _, sku := range skus {
+		seen[sku] = line
+	}
+
+	taken, err := skuTaken(ctx, db, skus, existing.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to check SKU: %v", err)
+	}
+	if taken {
+		return "", fmt.Errorf("SKU already exists on another product")
+	}
+
+	if dryRun {
+		return action, nil
+	}
+
+	now := time.Now()
+	product.UpdatedAt = now
+	product.SearchText = utils.ProductSearchText(product)
+
+	if action == "create" {
+		product.CreatedAt = now
+		product.Version = 1
+		product.Status = models.ProductActive
+		res, err := products.InsertOne(ctx, product)
+		if err != nil {
+			return "", fmt.Errorf("failed to create product: %v", err)
+		}
+		product.ID = res.InsertedID.(primitive.ObjectID)
+	} else {
+		set := bson.M{"searchText": product.SearchText, "updatedAt": now}
+		written := bson.M{
+			"title":         product.Title,
+			"titleAr":       product.TitleAr,
+			"description":   product.Description,
+			"descriptionAr": product.DescriptionAr,
+			"price":         product.Price,
+			"stock":         product.Stock,
+			"tag":           product.Tag,
+			"image":         product.Image,
+			"variants":      product.Variants,
+		}
+		for _, field := range importedFields {
+			if fields[field] {
+				set[field] = written[field]
+			}
+		}
+		// An empty column keeps the image. Products with a gallery change
+		// images through the image endpoints, and the rest get a gallery
+		if product.Image == "" || len(existing.Images) > 0 {
+			delete(set, "image")
+			product.Image = existing.Image
+		} else if fields["image"] {
+			if err := prepareProductImages(&product); err != nil {
+				return "", err
+			}
+			set["images"] = product.Images
+		}
+		if len(product.Variants) > 0 {
+			// Price and stock follow the variants, whatever the row says
+			delete(set, "price")
+			delete(set, "stock")
+			if fields["variants"] {
+				set["price"] = product.Price
+				set["stock"] = product.Stock
+			}
+		} else {
+			delete(set, "variants")
+		}
+		_, setsStock := set["stock"]
+
+		// Checkouts change stock without bumping the version, so a row that
+		// sets stock must also match the stock it was read with
+		filter := bson.M{"_id": existing.ID, "version": existing.Version}
+		if setsStock {
+			filter = guardStock(filter, existing)
+		}
+		product.ID = existing.ID
+		res, err := products.UpdateOne(ctx, filter, bumpVersion(bson.M{"$set": set}))
+		if err != nil {
+			return "", fmt.Errorf("failed to update product: %v", err)
+		}
+		if res.MatchedCount == 0 {
+			return "", fmt.Errorf("product was changed during the import, import the row again")
+		}
+
+		if setsStock {
+			recordStockAdjustment(ctx, db, existing, product, actorID)
+		}
+	}
+
+	// Updating an archived or deleted product leaves its status alone, and it
+	// stays out of collections until restored
+	if action == "create" || isProductVisible(existing) {
+		if err := syncTagCollections(ctx, db, product.ID, existing.Tag, product.Tag); err != nil {
+			return "", err
+		}
+	}
+	return action, nil
+}
+
+// ExportCatalog writes every product to w, ordered by SKU, in a format that
+// ImportCatalog reads back. Prices are exported before discounts.
+func ExportCatalog(ctx context.Context, db *mongo.Database, w io.Writer, format string) error {
+	if format != CatalogCSV && format != CatalogNDJSON {
+		return fmt.Errorf("format must be %s or %s", CatalogCSV, CatalogNDJSON)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "sku", Value: 1}})
+	cursor, err := db.Collection("products").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return fmt.Errorf("failed to load products: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var csvWriter *csv.Writer
+	encoder := json.NewEncoder(w)
+	if format == CatalogCSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write(catalogCSVHeader); err != nil {
+			return err
+		}
+	}
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return fmt.Errorf("failed to decode product: %w", err)
+		}
+		if csvWriter == nil {
+			if err := encoder.Encode(product); err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := csvWriter.Write([]string{
+			product.Sku,
+			product.Title,
+			product.TitleAr,
+			product.Description,
+			product.DescriptionAr,
+			strconv.FormatFloat(product.Price, 'f', -1, 64),
+			strconv.Itoa(int(product.Stock)),
+			strings.Join(product.Tag, "|"),
+			product.Image,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+		return csvWriter.Error()
+	}
+	return nil
+}
+
+// catalogFormat picks the import or export format from ?format=, falling
+// back to the Content-Type of an upload.
+func catalogFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		return CatalogCSV
+	}
+	return CatalogNDJSON
+}
+
+// ImportProductsHandler handles POST /products/import?format=csv|ndjson&dryRun=true
+func ImportProductsHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+	report, err := ImportCatalog(ctx, db, r.Body, catalogFormat(r), dryRun, utils.UserIDFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ExportProductsHandler handles GET /products/export?format=csv|ndjson
+func ExportProductsHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = CatalogCSV
+	}
+
+	contentType := "application/x-ndjson"
+	if format == CatalogCSV {
+		contentType = "text/csv; charset=utf-8"
+	} else if format != CatalogNDJSON {
+		http.Error(w, fmt.Sprintf("format must be %s or %s", CatalogCSV, CatalogNDJSON), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=products.%s", format))
+
+	// Once streaming started the status can no longer change
+	if err := ExportCatalog(ctx, db, w, format); err != nil {
+		fmt.Println("Error exporting products:", err)
+	}
+}
@@ -0,0 +1,300 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// checkoutRequest is everything a customer may choose at checkout. Items and
+// prices always come from the stored cart and catalog.
+type checkoutRequest struct {
+	PhoneNumber string `json:"phoneNumber"`
+	Location    string `json:"userLocation"`
+	CouponCode  string `json:"couponCode,omitempty"`
+}
+
+// statusError is a failure that should be reported to the client with the
+// given HTTP status.
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// Checkout handles POST /checkout. It turns the caller's cart into an order.
+func Checkout(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
+		return
+	}
+
+	var req checkoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	writeCheckoutResult(w, r, db, userID, req)
+}
+
+// writeCheckoutResult places the order and writes it, or the failure, to w.
+func writeCheckoutResult(w http.ResponseWriter, r *http.Request, db *mongo.Database, userID string, req checkoutRequest) {
+	ctx, cancel := context.WithTimeout(r.Context(), 15*time.Second)
+	defer cancel()
+
+	order, err := placeOrder(ctx, db, userID, req)
+	if err != nil {
+		if statusErr, ok := err.(*statusError); ok {
+			http.Error(w, statusErr.message, statusErr.status)
+			return
+		}
+		fmt.Println("Error placing order:", err)
+		http.Error(w, "Error creating order", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(order)
+}
+
+// placeOrder prices the user's cart from the catalog, applies the coupon,
+// reserves the stock, stores the order and empties the cart.
+func placeOrder(ctx context.Context, db *mongo.Database, userID string, req checkoutRequest) (models.Order, error) {
+	req.PhoneNumber = strings.TrimSpace(req.PhoneNumber)
+	req.Location = strings.TrimSpace(req.Location)
+	if req.PhoneNumber == "" || req.Location == "" {
+		return models.Order{}, &statusError{http.StatusBadRequest, "phoneNumber and userLocation are required"}
+	}
+
+	cart, err := loadCart(ctx, db, userID)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	orderNumber, err := nextOrderNumber(ctx, db)
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	order := models.Order{
+		ID:            primitive.NewObjectID(),
+		OrderID:       orderNumber,
+		PhoneNumber:   req.PhoneNumber,
+		UserID:        userID,
+		Location:      req.Location,
+		Items:         cart.Items,
+		Subtotal:      cart.Subtotal,
+		CartDiscount:  cart.CartDiscount,
+		Total:         cart.Total,
+		CreatedAt:     time.Now(),
+		Status:        models.OrderPending,
+		StockReserved: true, // Taken below, before the order is stored
+	}
+	order.StatusHistory = []models.OrderStatusChange{{Status: models.OrderPending, At: order.CreatedAt, ActorID: userID}}
+
+	var coupon models.Coupon
+	if strings.TrimSpace(req.CouponCode) != "" {
+		var discount float64
+		coupon, discount, err = quoteCoupon(ctx, db, req.CouponCode, userID, order.Total)
+		if err != nil {
+			return models.Order{}, err
+		}
+		order.CouponCode = coupon.Code
+		order.CouponID = coupon.ID
+		order.CouponDiscount = discount
+		order.Total = roundPrice(order.Total - discount)
+	}
+	order.Discounted = order.Total < order.Subtotal
+
+	if err := reserveStock(ctx, db, order.Items, order.OrderID); err != nil {
+		return models.Order{}, err
+	}
+
+	if order.CouponCode != "" {
+		if err := redeemCoupon(ctx, db, coupon, userID); err != nil {
+			restoreStock(ctx, db, order.Items, order.OrderID, models.StockReservationRollback)
+			return models.Order{}, err
+		}
+	}
+
+	if _, err := db.Collection("orders").InsertOne(ctx, order); err != nil {
+		restoreStock(ctx, db, order.Items, order.OrderID, models.StockReservationRollback)
+		if order.CouponCode != "" {
+			releaseCoupon(ctx, db, coupon, userID)
+		}
+		return models.Order{}, fmt.Errorf("failed to insert order: %w", err)
+	}
+
+	// The order stands even if the cart could not be emptied
+	if _, err := db.Collection("carts").UpdateOne(ctx, bson.M{"userId": userID}, bson.M{"$set": bson.M{"items": []models.CartItem{}}}); err != nil {
+		fmt.Printf("Warning: failed to clear cart for user %s: %v\n", userID, err)
+	}
+
+	return order, nil
+}
+
+// pricedCart is a cart priced for checkout, before any coupon.
+type pricedCart struct {
+	Items        []models.OrderItem
+	Subtotal     float64 // At catalog prices
+	CartDiscount float64 // Taken off by the best cart-wide discount
+	Total        float64 // After every automatic discount
+}
+
+// loadCart prices the cart of userID with the active discounts.
+func loadCart(ctx context.Context, db *mongo.Database, userID string) (pricedCart, error) {
+	var cart models.Cart
+	err := db.Collection("carts").FindOne(ctx, bson.M{"userId": userID}).Decode(&cart)
+	if err == mongo.ErrNoDocuments || (err == nil && len(cart.Items) == 0) {
+		return pricedCart{}, &statusError{http.StatusBadRequest, "Cart is empty"}
+	} else if err != nil {
+		return pricedCart{}, fmt.Errorf("failed to load cart: %w", err)
+	}
+
+	book, err := loadPriceBook(ctx, db)
+	if err != nil {
+		return pricedCart{}, err
+	}
+	return priceCart(ctx, db, book, cart.Items)
+}
+
+// priceCart prices cartItems with the discounts in book.
+func priceCart(ctx context.Context, db *mongo.Database, book *priceBook, cartItems []models.CartItem) (pricedCart, error) {
+	items, err := priceCartItems(ctx, db, book, cartItems)
+	if err != nil {
+		return pricedCart{}, err
+	}
+
+	cart := pricedCart{Items: items}
+	for _, item := range items {
+		cart.Subtotal += item.UnitPrice * float64(item.Quantity)
+		cart.Total += item.LineTotal
+	}
+	cart.Subtotal = roundPrice(cart.Subtotal)
+	cart.Total = roundPrice(cart.Total)
+	cart.CartDiscount = book.cartDiscountFor(cart.Total)
+	cart.Total = roundPrice(cart.Total - cart.CartDiscount)
+	return cart, nil
+}
+
+// cartLine identifies a distinct product, or variant of a product, in a cart.
+type cartLine struct {
+	productID primitive.ObjectID
+	variantID string
+}
+
+// priceCartItems merges duplicate cart lines and prices each one from the
+// products collection and the discounts in book.
+func priceCartItems(ctx context.Context, db *mongo.Database, book *priceBook, cartItems []models.CartItem) ([]models.OrderItem, error) {
+	quantities := map[cartLine]int{}
+	var lines []cartLine
+	var productIDs []primitive.ObjectID
+	for _, item := range cartItems {
+		productID, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Unknown product %q in cart", item.ProductID)}
+		}
+		if item.Quantity <= 0 {
+			return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Invalid quantity for product %s", item.ProductID)}
+		}
+		line := cartLine{productID, item.VariantID}
+		if _, seen := quantities[line]; !seen {
+			lines = append(lines, line)
+			productIDs = append(productIDs, productID)
+		}
+		quantities[line] += item.Quantity
+	}
+
+	cursor, err := db.Collection("products").Find(ctx, bson.M{"_id": bson.M{"$in": productIDs}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load products: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return nil, fmt.Errorf("failed to decode products: %w", err)
+	}
+
+	byID := make(map[primitive.ObjectID]models.Product, len(products))
+	for _, product := range products {
+		byID[product.ID] = product
+	}
+
+	items := make([]models.OrderItem, 0, len(lines))
+	for _, line := range lines {
+		product, found := byID[line.productID]
+		if !found {
+			return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Unknown product %s in cart", line.productID.Hex())}
+		}
+		if !isProductVisible(product) {
+			return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("%s is no longer available", product.Title)}
+		}
+
+		quantity := quantities[line]
+		item := models.OrderItem{
+			ProductID: line.productID.Hex(),
+			Quantity:  quantity,
+			Sku:       product.Sku,
+			Title:     product.Title,
+			TitleAr:   product.TitleAr,
+		}
+
+		var stock int32
+		switch {
+		case line.variantID != "":
+			variant, found := findVariant(product, line.variantID)
+			if !found {
+				return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Unknown variant %s of %s in cart", line.variantID, product.Title)}
+			}
+			item.VariantID = line.variantID
+			item.Sku = variant.Sku
+			item.Attributes = variant.Attributes
+			item.UnitPrice = variant.Price
+			item.DiscountPercentage = book.variantDiscountFor(product.ID, variant.ID)
+			stock = variant.Stock
+		case len(product.Variants) > 0:
+			return nil, &statusError{http.StatusBadRequest, fmt.Sprintf("Choose a variant of %s", product.Title)}
+		default:
+			item.UnitPrice = product.Price
+			item.DiscountPercentage = book.discountFor(product.ID)
+			stock = product.Stock
+		}
+
+		if int(stock) < quantity {
+			return nil, &statusError{http.StatusConflict, fmt.Sprintf("%s is out of stock", product.Title)}
+		}
+
+		if tier := book.tierDiscountFor(product.ID, quantity); tier > item.DiscountPercentage {
+			item.DiscountPercentage = tier
+		}
+		item.FinalUnitPrice = discountedPrice(item.UnitPrice, item.DiscountPercentage)
+		item.LineTotal = roundPrice(item.FinalUnitPrice * float64(quantity))
+
+		// A buy-X-get-Y discount replaces the others when it saves more
+		if bundle := book.bundleDiscountFor(product.ID, quantity, item.UnitPrice); bundle > 0 {
+			if lineTotal := roundPrice(item.UnitPrice*float64(quantity) - bundle); lineTotal < item.LineTotal {
+				item.DiscountPercentage = 0
+				item.FinalUnitPrice = item.UnitPrice
+				item.PromotionDiscount = bundle
+				item.LineTotal = lineTotal
+			}
+		}
+		items = append(items, item)
+	}
+
+	return items, nil
+}
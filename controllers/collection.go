@@ -3,6 +3,7 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
@@ -34,6 +35,7 @@ func CreateCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database
 
 	// Assign a new ObjectID
 	collection.ID = primitive.NewObjectID()
+	collection.Version = 1
 
 	_, err = collectionCollection.InsertOne(context.TODO(), collection)
 	if err != nil {
@@ -41,6 +43,9 @@ func CreateCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database
 		return
 	}
 
+	catalogSuggestions.refresh(db)
+
+	setVersionETag(w, collection.Version)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(collection)
 }
@@ -84,6 +89,7 @@ func GetCollectionByID(w http.ResponseWriter, r *http.Request, db *mongo.Databas
 		return
 	}
 
+	setVersionETag(w, collection.Version)
 	json.NewEncoder(w).Encode(collection)
 }
 
@@ -98,6 +104,11 @@ func UpdateCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database
 		return
 	}
 
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	// Decode only the fields expected to be updated
 	var updateFields map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&updateFields); err != nil {
@@ -107,22 +118,83 @@ func UpdateCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database
 
 	// Remove _id if present (prevent overriding the document ID)
 	delete(updateFields, "_id")
+	delete(updateFields, "id")
+	delete(updateFields, "version")
+
+	// Members arrive as hex strings but must be stored as ObjectIDs, or
+	// collection discounts and listings would not find them
+	if raw, ok := updateFields["productIds"]; ok {
+		productIDs, err := collectionProductIDs(context.TODO(), db, raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		updateFields["productIds"] = productIDs
+	}
 
 	collectionCollection := db.Collection("collections")
 	result, err := collectionCollection.UpdateOne(
 		context.TODO(),
-		bson.M{"_id": objID},
-		bson.M{"$set": updateFields},
+		bson.M{"_id": objID, "version": ifMatch},
+		bumpVersion(bson.M{"$set": updateFields}),
 	)
-	if err != nil || result.MatchedCount == 0 {
+	if err != nil {
 		http.Error(w, "Failed to update collection", http.StatusInternalServerError)
 		return
 	}
+	if result.MatchedCount == 0 {
+		count, err := collectionCollection.CountDocuments(context.TODO(), bson.M{"_id": objID})
+		if err == nil && count == 0 {
+			http.Error(w, "Collection not found", http.StatusNotFound)
+			return
+		}
+		writeVersionConflict(w)
+		return
+	}
+
+	catalogSuggestions.refresh(db)
 
+	setVersionETag(w, ifMatch+1)
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(bson.M{"updated": true})
 }
 
+// collectionProductIDs converts the productIds of a collection update to
+// ObjectIDs and checks that every product exists and is active, since
+// archived and deleted products are kept out of collections.
+func collectionProductIDs(ctx context.Context, db *mongo.Database, raw interface{}) ([]primitive.ObjectID, error) {
+	productIDs := []primitive.ObjectID{}
+	if raw == nil {
+		return productIDs, nil
+	}
+	values, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("productIds must be an array of product IDs")
+	}
+
+	seen := map[primitive.ObjectID]bool{}
+	for _, value := range values {
+		hex, _ := value.(string)
+		productID, err := primitive.ObjectIDFromHex(hex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product ID %v in productIds", value)
+		}
+		if !seen[productID] {
+			seen[productID] = true
+			productIDs = append(productIDs, productID)
+		}
+	}
+
+	count, err := db.Collection("products").CountDocuments(ctx, bson.M{"_id": bson.M{"$in": productIDs}, "status": models.ProductActive})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check products: %w", err)
+	}
+	if int(count) != len(productIDs) {
+		return nil, fmt.Errorf("productIds contains products that do not exist or are not active")
+	}
+	return productIDs, nil
+}
+
 // DeleteCollection - Remove a collection
 func DeleteCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	vars := mux.Vars(r)
@@ -141,6 +213,8 @@ func DeleteCollection(w http.ResponseWriter, r *http.Request, db *mongo.Database
 		return
 	}
 
+	catalogSuggestions.refresh(db)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -156,6 +230,12 @@ func GetProductsByCollection(w http.ResponseWriter, r *http.Request, db *mongo.D
 
 	isArabic := strings.Contains(r.URL.Path, "/ar")
 
+	filter, sort, page, err := productQueryFromRequest(r, isArabic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	collectionCollection := db.Collection("collections")
 	var collection models.Collection
 	err = collectionCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&collection)
@@ -165,28 +245,11 @@ func GetProductsByCollection(w http.ResponseWriter, r *http.Request, db *mongo.D
 	}
 
 	if len(collection.ProductIds) == 0 {
-		json.NewEncoder(w).Encode([]models.Product{})
+		json.NewEncoder(w).Encode(pageResponse([]models.Product{}, page, 0))
 		return
 	}
 
-	productCollection := db.Collection("products")
-	cursor, err := productCollection.Find(context.TODO(), bson.M{"_id": bson.M{"$in": collection.ProductIds}})
-	if err != nil {
-		http.Error(w, "Failed to retrieve products", http.StatusInternalServerError)
-		return
-	}
-	defer cursor.Close(context.TODO())
-
-	var products []models.Product
-	if err := cursor.All(context.TODO(), &products); err != nil {
-		http.Error(w, "Failed to parse products", http.StatusInternalServerError)
-		return
-	}
-
-	var response []map[string]interface{}
-	for _, product := range products {
-		response = append(response, formatProductResponse(product, isArabic, false))
-	}
-
-	json.NewEncoder(w).Encode(response)
+	filter["_id"] = bson.M{"$in": collection.ProductIds}
+	filter["status"] = models.ProductActive
+	listProducts(w, db, filter, sort, page, isArabic, false)
 }
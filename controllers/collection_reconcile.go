@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// collectionDrift is the difference found between a collection's stored
+// members and the products tagged with its name.
+type collectionDrift struct {
+	CollectionID   string   `json:"collectionId"`
+	CollectionName string   `json:"collectionName"`
+	Created        bool     `json:"created,omitempty"` // No collection existed for the tag
+	Added          []string `json:"added,omitempty"`
+	Removed        []string `json:"removed,omitempty"`
+	Retyped        int      `json:"retyped,omitempty"` // Members stored as strings instead of ObjectIDs
+}
+
+// reconcileReport is the result of a collection reconcile run.
+type reconcileReport struct {
+	DryRun             bool              `json:"dryRun"`
+	CollectionsChecked int               `json:"collectionsChecked"`
+	CollectionsFixed   int               `json:"collectionsFixed"`
+	Drift              []collectionDrift `json:"drift"`
+}
+
+// reconcileCollections rebuilds the members of every collection named after
+// a product tag from the tags of the active products, the way CreateProduct
+// and the product updates keep them, and creates the collections missing for
+// a tag. Collections whose name is no product's tag are curated by hand and
+// keep their members; only members stored as strings are fixed. Unless
+// dryRun the fixes are written.
+func reconcileCollections(ctx context.Context, db *mongo.Database, dryRun bool) (reconcileReport, error) {
+	report := reconcileReport{DryRun: dryRun, Drift: []collectionDrift{}}
+
+	// Active products per tag, in a stable order. Tags of archived and
+	// deleted products still mark their collection as managed by tags.
+	opts := options.Find().SetProjection(bson.M{"tag": 1, "status": 1}).SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := db.Collection("products").Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return report, fmt.Errorf("failed to load products: %w", err)
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return report, fmt.Errorf("failed to decode products: %w", err)
+	}
+
+	tagged := map[string][]primitive.ObjectID{}
+	tags := map[string]bool{}
+	for _, product := range products {
+		for _, tag := range product.Tag {
+			tags[tag] = true
+			if product.Status == models.ProductActive {
+				tagged[tag] = append(tagged[tag], product.ID)
+			}
+		}
+	}
+
+	// Members are read loosely since some were stored as strings
+	collections := db.Collection("collections")
+	cursor, err = collections.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "collectionName", Value: 1}}))
+	if err != nil {
+		return report, fmt.Errorf("failed to load collections: %w", err)
+	}
+	var stored []struct {
+		ID             primitive.ObjectID `bson:"_id"`
+		CollectionName string             `bson:"collectionName"`
+		ProductIds     []interface{}      `bson:"productIds"`
+	}
+	if err := cursor.All(ctx, &stored); err != nil {
+		return report, fmt.Errorf("failed to decode collections: %w", err)
+	}
+
+	for _, collection := range stored {
+		report.CollectionsChecked++
+		expected := tagged[collection.CollectionName]
+		delete(tagged, collection.CollectionName)
+
+		drift := collectionDrift{CollectionID: collection.ID.Hex(), CollectionName: collection.CollectionName}
+		current := map[string]bool{}
+		var members []primitive.ObjectID
+		for _, member := range collection.ProductIds {
+			switch id := member.(type) {
+			case primitive.ObjectID:
+				current[id.Hex()] = true
+				members = append(members, id)
+			case string:
+				current[id] = true
+				drift.Retyped++
+				if objID, err := primitive.ObjectIDFromHex(id); err == nil {
+					members = append(members, objID)
+				}
+			}
+		}
+
+		if !tags[collection.CollectionName] {
+			if drift.Retyped == 0 {
+				continue
+			}
+			report.CollectionsFixed++
+			report.Drift = append(report.Drift, drift)
+			if dryRun {
+				continue
+			}
+			if members == nil {
+				members = []primitive.ObjectID{}
+			}
+			if _, err := collections.UpdateByID(ctx, collection.ID, bumpVersion(bson.M{"$set": bson.M{"productIds": members}})); err != nil {
+				return report, fmt.Errorf("failed to update collection %s: %w", collection.CollectionName, err)
+			}
+			continue
+		}
+
+		for _, productID := range expected {
+			if !current[productID.Hex()] {
+				drift.Added = append(drift.Added, productID.Hex())
+			}
+			delete(current, productID.Hex())
+		}
+		for productID := range current {
+			drift.Removed = append(drift.Removed, productID)
+		}
+		sort.Strings(drift.Removed)
+
+		if len(drift.Added) == 0 && len(drift.Removed) == 0 && drift.Retyped == 0 {
+			continue
+		}
+		report.CollectionsFixed++
+		report.Drift = append(report.Drift, drift)
+
+		if dryRun {
+			continue
+		}
+		if expected == nil {
+			expected = []primitive.ObjectID{}
+		}
+		if _, err := collections.UpdateByID(ctx, collection.ID, bumpVersion(bson.M{"$set": bson.M{"productIds": expected}})); err != nil {
+			return report, fmt.Errorf("failed to update collection %s: %w", collection.CollectionName, err)
+		}
+	}
+
+	// Tags that have no collection yet
+	var missing []string
+	for tag := range tagged {
+		missing = append(missing, tag)
+	}
+	sort.Strings(missing)
+	for _, tag := range missing {
+		collection := models.Collection{
+			ID:             primitive.NewObjectID(),
+			CollectionName: tag,
+			ProductIds:     tagged[tag],
+			ShowCollection: true,
+			Version:        1,
+		}
+
+		drift := collectionDrift{CollectionID: collection.ID.Hex(), CollectionName: tag, Created: true}
+		for _, productID := range collection.ProductIds {
+			drift.Added = append(drift.Added, productID.Hex())
+		}
+		report.CollectionsFixed++
+		report.Drift = append(report.Drift, drift)
+
+		if dryRun {
+			continue
+		}
+		if _, err := collections.InsertOne(ctx, collection); err != nil {
+			return report, fmt.Errorf("failed to create collection %s: %w", tag, err)
+		}
+	}
+
+	return report, nil
+}
+
+// ReconcileCollections handles POST /admin/collections/reconcile?dryRun=true
+func ReconcileCollections(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	report, err := reconcileCollections(ctx, db, dryRun)
+	if err != nil {
+		fmt.Println("Error reconciling collections:", err)
+		http.Error(w, "Failed to reconcile collections", http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun && report.CollectionsFixed > 0 {
+		added, removed := 0, 0
+		for _, drift := range report.Drift {
+			added += len(drift.Added)
+			removed += len(drift.Removed)
+		}
+		recordAuditEvent(ctx, db, models.AuditEvent{
+			Type:    models.AuditCollectionsReconciled,
+			ActorID: utils.UserIDFromContext(r.Context()),
+			Details: map[string]interface{}{
+				"collectionsChecked": report.CollectionsChecked,
+				"collectionsFixed":   report.CollectionsFixed,
+				"added":              added,
+				"removed":            removed,
+			},
+		})
+		catalogSuggestions.refresh(db)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
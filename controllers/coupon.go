@@ -0,0 +1,424 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// couponCodePattern is what a normalized coupon code may contain.
+var couponCodePattern = regexp.MustCompile(`^[A-Z0-9_-]{3,32}$`)
+
+// normalizeCouponCode makes codes match whatever case the customer types.
+func normalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// validateCoupon checks the fields an admin sets on a coupon.
+func validateCoupon(coupon models.Coupon) error {
+	if !couponCodePattern.MatchString(coupon.Code) {
+		return fmt.Errorf("code must be 3 to 32 letters, digits, dashes or underscores")
+	}
+	switch coupon.Type {
+	case models.CouponPercentage:
+		if coupon.Value <= 0 || coupon.Value > 100 {
+			return fmt.Errorf("a percentage coupon needs a value between 0 and 100")
+		}
+	case models.CouponFixed:
+		if coupon.Value <= 0 {
+			return fmt.Errorf("a fixed coupon needs a positive value")
+		}
+	default:
+		return fmt.Errorf("type must be percentage or fixed")
+	}
+	if coupon.MinSubtotal < 0 || coupon.MaxUses < 0 || coupon.MaxUsesPerUser < 0 {
+		return fmt.Errorf("minSubtotal, maxUses and maxUsesPerUser cannot be negative")
+	}
+	return nil
+}
+
+// couponRedemptionID is the ID of the redemption count of a coupon by a user.
+func couponRedemptionID(couponID primitive.ObjectID, userID string) string {
+	return couponID.Hex() + ":" + userID
+}
+
+// couponAmount is what coupon takes off an order total, never more than the
+// total itself.
+func couponAmount(coupon models.Coupon, total float64) float64 {
+	amount := coupon.Value
+	if coupon.Type == models.CouponPercentage {
+		amount = total * coupon.Value / 100
+	}
+	if amount > total {
+		amount = total
+	}
+	return roundPrice(amount)
+}
+
+// quoteCoupon looks up code and checks that userID can use it on an order
+// totalling total, after the automatic discounts. It returns the coupon and
+// the amount it takes off. The usage caps are checked again, atomically,
+// when the coupon is redeemed.
+func quoteCoupon(ctx context.Context, db *mongo.Database, code string, userID string, total float64) (models.Coupon, float64, error) {
+	var coupon models.Coupon
+	err := db.Collection("coupons").FindOne(ctx, bson.M{"code": normalizeCouponCode(code)}).Decode(&coupon)
+	if err == mongo.ErrNoDocuments {
+		return coupon, 0, &statusError{http.StatusNotFound, "Unknown coupon code"}
+	} else if err != nil {
+		return coupon, 0, fmt.Errorf("failed to load coupon: %w", err)
+	}
+
+	if err := checkCouponUsable(coupon, total, time.Now()); err != nil {
+		return coupon, 0, err
+	}
+
+	if coupon.MaxUsesPerUser > 0 {
+		var redemption models.CouponRedemption
+		err := db.Collection("coupon_redemptions").FindOne(ctx, bson.M{"_id": couponRedemptionID(coupon.ID, userID)}).Decode(&redemption)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return coupon, 0, fmt.Errorf("failed to load coupon redemptions: %w", err)
+		}
+		if redemption.Uses >= coupon.MaxUsesPerUser {
+			return coupon, 0, &statusError{http.StatusConflict, "You have already used this coupon"}
+		}
+	}
+
+	return coupon, couponAmount(coupon, total), nil
+}
+
+// checkCouponUsable checks that coupon can be used at now on an order
+// totalling total. The per-user cap needs the user's redemptions and is
+// checked by quoteCoupon.
+func checkCouponUsable(coupon models.Coupon, total float64, now time.Time) error {
+	if coupon.ExpiresAt != nil && !now.Before(*coupon.ExpiresAt) {
+		return &statusError{http.StatusBadRequest, "This coupon has expired"}
+	}
+	if total < coupon.MinSubtotal {
+		return &statusError{http.StatusBadRequest, fmt.Sprintf("This coupon needs an order of at least %.2f", coupon.MinSubtotal)}
+	}
+	if coupon.MaxUses > 0 && coupon.Uses >= coupon.MaxUses {
+		return &statusError{http.StatusConflict, "This coupon has been fully redeemed"}
+	}
+	return nil
+}
+
+// redeemCoupon records one use of coupon by userID. Both caps are enforced
+// by the update filters, so concurrent checkouts cannot go over them.
+func redeemCoupon(ctx context.Context, db *mongo.Database, coupon models.Coupon, userID string) error {
+	coupons := db.Collection("coupons")
+	now := time.Now()
+
+	filter := bson.M{
+		"_id": coupon.ID,
+		"$and": bson.A{
+			bson.M{"$or": bson.A{bson.M{"maxUses": 0}, bson.M{"$expr": bson.M{"$lt": bson.A{"$uses", "$maxUses"}}}}},
+			bson.M{"$or": bson.A{bson.M{"expiresAt": nil}, bson.M{"expiresAt": bson.M{"$gt": now}}}},
+		},
+	}
+	res, err := coupons.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"uses": 1}})
+	if err != nil {
+		return fmt.Errorf("failed to redeem coupon: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return &statusError{http.StatusConflict, "This coupon is no longer available"}
+	}
+
+	// The redemption ID is fixed per user, so once the cap is reached the
+	// filter stops matching and the upsert fails on the duplicate ID
+	redemptionFilter := bson.M{"_id": couponRedemptionID(coupon.ID, userID)}
+	if coupon.MaxUsesPerUser > 0 {
+		redemptionFilter["uses"] = bson.M{"$lt": coupon.MaxUsesPerUser}
+	}
+	_, err = db.Collection("coupon_redemptions").UpdateOne(ctx, redemptionFilter,
+		bson.M{
+			"$inc":         bson.M{"uses": 1},
+			"$set":         bson.M{"updatedAt": now},
+			"$setOnInsert": bson.M{"couponId": coupon.ID, "userId": userID},
+		},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		if _, undoErr := coupons.UpdateByID(ctx, coupon.ID, bson.M{"$inc": bson.M{"uses": -1}}); undoErr != nil {
+			fmt.Printf("Warning: failed to release coupon %s: %v\n", coupon.Code, undoErr)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return &statusError{http.StatusConflict, "You have already used this coupon"}
+		}
+		return fmt.Errorf("failed to record coupon redemption: %w", err)
+	}
+	return nil
+}
+
+// releaseCoupon takes back a redemption whose order could not be placed or
+// was cancelled.
+func releaseCoupon(ctx context.Context, db *mongo.Database, coupon models.Coupon, userID string) {
+	if _, err := db.Collection("coupons").UpdateByID(ctx, coupon.ID, bson.M{"$inc": bson.M{"uses": -1}}); err != nil {
+		fmt.Printf("Warning: failed to release coupon %s: %v\n", coupon.Code, err)
+	}
+	_, err := db.Collection("coupon_redemptions").UpdateByID(ctx, couponRedemptionID(coupon.ID, userID), bson.M{"$inc": bson.M{"uses": -1}})
+	if err != nil {
+		fmt.Printf("Warning: failed to release coupon %s for user %s: %v\n", coupon.Code, userID, err)
+	}
+}
+
+// releaseOrderCoupon gives back the coupon use of a cancelled order. The
+// couponReleased flag makes sure it happens only once per order.
+func releaseOrderCoupon(ctx context.Context, db *mongo.Database, order models.Order) error {
+	if order.CouponCode == "" {
+		return nil
+	}
+	res, err := db.Collection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "couponReleased": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"couponReleased": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+
+	// Orders placed before the coupon ID was stored only have the code
+	coupon := models.Coupon{ID: order.CouponID, Code: order.CouponCode}
+	if coupon.ID.IsZero() {
+		err := db.Collection("coupons").FindOne(ctx, bson.M{"code": normalizeCouponCode(order.CouponCode)}).Decode(&coupon)
+		if err == mongo.ErrNoDocuments {
+			fmt.Printf("Warning: coupon %s of order %s no longer exists\n", order.CouponCode, order.OrderID)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	releaseCoupon(ctx, db, coupon, order.UserID)
+	return nil
+}
+
+// couponQuote is the result of validating a coupon against a cart.
+type couponQuote struct {
+	Code     string  `json:"code"`
+	Type     string  `json:"type"`
+	Value    float64 `json:"value"`
+	Subtotal float64 `json:"subtotal"` // Cart total after the automatic discounts
+	Discount float64 `json:"discount"`
+	Total    float64 `json:"total"`
+}
+
+// quoteCartCoupon prices the cart of userID with the coupon code.
+func quoteCartCoupon(ctx context.Context, db *mongo.Database, userID string, code string) (couponQuote, error) {
+	cart, err := loadCart(ctx, db, userID)
+	if err != nil {
+		return couponQuote{}, err
+	}
+	subtotal := cart.Total
+
+	coupon, discount, err := quoteCoupon(ctx, db, code, userID, subtotal)
+	if err != nil {
+		return couponQuote{}, err
+	}
+	return couponQuote{
+		Code:     coupon.Code,
+		Type:     coupon.Type,
+		Value:    coupon.Value,
+		Subtotal: subtotal,
+		Discount: discount,
+		Total:    roundPrice(subtotal - discount),
+	}, nil
+}
+
+// ValidateCoupon handles POST /coupons/validate. It prices the caller's cart
+// with the coupon in the body without redeeming it.
+func ValidateCoupon(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var req struct {
+		CouponCode string `json:"couponCode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.CouponCode) == "" {
+		http.Error(w, "couponCode is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	quote, err := quoteCartCoupon(ctx, db, utils.UserIDFromContext(r.Context()), req.CouponCode)
+	if err != nil {
+		if statusErr, ok := err.(*statusError); ok {
+			http.Error(w, statusErr.message, statusErr.status)
+			return
+		}
+		fmt.Println("Error validating coupon:", err)
+		http.Error(w, "Failed to validate coupon", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(quote)
+}
+
+// CreateCoupon handles POST /coupons
+func CreateCoupon(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var coupon models.Coupon
+	if err := json.NewDecoder(r.Body).Decode(&coupon); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	if err := validateCoupon(coupon); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	coupon.ID = primitive.NewObjectID()
+	coupon.Uses = 0
+	coupon.Version = 1
+	coupon.CreatedAt = time.Now()
+	coupon.UpdatedAt = coupon.CreatedAt
+
+	if _, err := db.Collection("coupons").InsertOne(ctx, coupon); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "Coupon code already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to create coupon", http.StatusInternalServerError)
+		return
+	}
+
+	setVersionETag(w, coupon.Version)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(coupon)
+}
+
+// GetCoupons handles GET /coupons
+func GetCoupons(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := db.Collection("coupons").Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}}))
+	if err != nil {
+		http.Error(w, "Failed to fetch coupons", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	coupons := []models.Coupon{}
+	if err := cursor.All(ctx, &coupons); err != nil {
+		http.Error(w, "Error reading coupons", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(coupons)
+}
+
+// UpdateCoupon handles PUT /coupons/{id}. The usage count is kept.
+func UpdateCoupon(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	couponID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var coupon models.Coupon
+	if err := json.NewDecoder(r.Body).Decode(&coupon); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	coupon.Code = normalizeCouponCode(coupon.Code)
+	if err := validateCoupon(coupon); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	coupons := db.Collection("coupons")
+	update := bson.M{"$set": bson.M{
+		"code":           coupon.Code,
+		"type":           coupon.Type,
+		"value":          coupon.Value,
+		"minSubtotal":    coupon.MinSubtotal,
+		"maxUses":        coupon.MaxUses,
+		"maxUsesPerUser": coupon.MaxUsesPerUser,
+		"expiresAt":      coupon.ExpiresAt,
+		"updatedAt":      time.Now(),
+	}}
+	res, err := coupons.UpdateOne(ctx, bson.M{"_id": couponID, "version": ifMatch}, bumpVersion(update))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "Coupon code already exists", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update coupon", http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		if count, _ := coupons.CountDocuments(ctx, bson.M{"_id": couponID}); count == 0 {
+			http.Error(w, "Coupon not found", http.StatusNotFound)
+			return
+		}
+		writeVersionConflict(w)
+		return
+	}
+
+	var updated models.Coupon
+	if err := coupons.FindOne(ctx, bson.M{"_id": couponID}).Decode(&updated); err != nil {
+		http.Error(w, "Coupon not found", http.StatusNotFound)
+		return
+	}
+
+	setVersionETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteCoupon handles DELETE /coupons/{id}. Orders keep the code they used.
+func DeleteCoupon(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	couponID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	res, err := db.Collection("coupons").DeleteOne(ctx, bson.M{"_id": couponID})
+	if err != nil {
+		http.Error(w, "Failed to delete coupon", http.StatusInternalServerError)
+		return
+	}
+	if res.DeletedCount == 0 {
+		http.Error(w, "Coupon not found", http.StatusNotFound)
+		return
+	}
+
+	if _, err := db.Collection("coupon_redemptions").DeleteMany(ctx, bson.M{"couponId": couponID}); err != nil {
+		fmt.Printf("Warning: failed to delete redemptions of coupon %s: %v\n", couponID.Hex(), err)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestCouponAmount(t *testing.T) {
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		total  float64
+		want   float64
+	}{
+		{"percentage", models.Coupon{Type: models.CouponPercentage, Value: 10}, 80, 8},
+		{"percentage rounded to cents", models.Coupon{Type: models.CouponPercentage, Value: 15}, 33.33, 5},
+		{"full percentage", models.Coupon{Type: models.CouponPercentage, Value: 100}, 42.5, 42.5},
+		{"fixed", models.Coupon{Type: models.CouponFixed, Value: 15}, 80, 15},
+		{"fixed above the total", models.Coupon{Type: models.CouponFixed, Value: 50}, 30, 30},
+		{"empty order", models.Coupon{Type: models.CouponFixed, Value: 5}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := couponAmount(tt.coupon, tt.total); got != tt.want {
+			t.Errorf("%s: couponAmount = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCouponUsable(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		coupon models.Coupon
+		total  float64
+		want   int // HTTP status of the error, 0 when usable
+	}{
+		{"unlimited", models.Coupon{Uses: 1000}, 50, 0},
+		{"not yet expired", models.Coupon{ExpiresAt: &future}, 50, 0},
+		{"expired", models.Coupon{ExpiresAt: &past}, 50, http.StatusBadRequest},
+		{"expires now", models.Coupon{ExpiresAt: &now}, 50, http.StatusBadRequest},
+		{"below minimum subtotal", models.Coupon{MinSubtotal: 100}, 99.99, http.StatusBadRequest},
+		{"at minimum subtotal", models.Coupon{MinSubtotal: 100}, 100, 0},
+		{"uses left", models.Coupon{MaxUses: 10, Uses: 9}, 50, 0},
+		{"fully redeemed", models.Coupon{MaxUses: 10, Uses: 10}, 50, http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCouponUsable(tt.coupon, tt.total, now)
+			if tt.want == 0 {
+				if err != nil {
+					t.Errorf("checkCouponUsable = %v, want nil", err)
+				}
+				return
+			}
+			statusErr, ok := err.(*statusError)
+			if !ok || statusErr.status != tt.want {
+				t.Errorf("checkCouponUsable = %v, want status %d", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedeemCoupon(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	coupon := models.Coupon{ID: primitive.NewObjectID(), Code: "SPRING", MaxUses: 5, MaxUsesPerUser: 1}
+
+	updated := func(matched int) bson.D {
+		return mtest.CreateSuccessResponse(bson.E{Key: "n", Value: matched}, bson.E{Key: "nModified", Value: matched})
+	}
+
+	mt.Run("redeems", func(mt *mtest.T) {
+		mt.AddMockResponses(updated(1), updated(1))
+
+		if err := redeemCoupon(context.Background(), mt.DB, coupon, "user"); err != nil {
+			t.Fatalf("redeemCoupon = %v, want nil", err)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 2 {
+			t.Errorf("sent %d commands, want 2", len(started))
+		}
+	})
+
+	mt.Run("coupon cap reached", func(mt *mtest.T) {
+		mt.AddMockResponses(updated(0))
+
+		err := redeemCoupon(context.Background(), mt.DB, coupon, "user")
+		if statusErr, ok := err.(*statusError); !ok || statusErr.status != http.StatusConflict {
+			t.Fatalf("redeemCoupon = %v, want a conflict", err)
+		}
+		if started := mt.GetAllStartedEvents(); len(started) != 1 {
+			t.Errorf("sent %d commands, want only the coupon update", len(started))
+		}
+	})
+
+	mt.Run("user cap reached", func(mt *mtest.T) {
+		duplicate := mtest.CreateWriteErrorsResponse(mtest.WriteError{Index: 0, Code: 11000, Message: "duplicate key error"})
+		mt.AddMockResponses(updated(1), duplicate, updated(1))
+
+		err := redeemCoupon(context.Background(), mt.DB, coupon, "user")
+		if statusErr, ok := err.(*statusError); !ok || statusErr.status != http.StatusConflict {
+			t.Fatalf("redeemCoupon = %v, want a conflict", err)
+		}
+
+		// The coupon use taken first must be given back
+		started := mt.GetAllStartedEvents()
+		if len(started) != 3 {
+			t.Fatalf("sent %d commands, want 3", len(started))
+		}
+		undo := started[2].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("u", "$inc", "uses")
+		if undo.Int32() != -1 {
+			t.Errorf("undo incremented uses by %v, want -1", undo)
+		}
+	})
+
+	mt.Run("redemption filter enforces the user cap", func(mt *mtest.T) {
+		mt.AddMockResponses(updated(1), updated(1))
+
+		if err := redeemCoupon(context.Background(), mt.DB, coupon, "user"); err != nil {
+			t.Fatalf("redeemCoupon = %v, want nil", err)
+		}
+		started := mt.GetAllStartedEvents()
+		update := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		if !update.Lookup("upsert").Boolean() {
+			t.Errorf("redemption update is not an upsert")
+		}
+		var filter struct {
+			ID   string `bson:"_id"`
+			Uses bson.M `bson:"uses"`
+		}
+		if err := update.Lookup("q").Unmarshal(&filter); err != nil {
+			t.Fatal(err)
+		}
+		if filter.ID != couponRedemptionID(coupon.ID, "user") {
+			t.Errorf("redemption _id = %q", filter.ID)
+		}
+		if filter.Uses["$lt"] != int32(coupon.MaxUsesPerUser) {
+			t.Errorf("redemption filter uses = %v, want $lt %d", filter.Uses, coupon.MaxUsesPerUser)
+		}
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"oldsouqs-backend/models"
@@ -31,134 +32,154 @@ func NewDiscountController(db *mongo.Database) *DiscountController {
 	}
 }
 
-// applyDiscount logic: saves original price and updates to discounted price
-func (dc *DiscountController) applyDiscount(ctx context.Context, discount models.Discount) error {
-	newPriceFunc := func(originalPrice float64, percentage float64) float64 {
-		return originalPrice - (originalPrice * percentage / 100)
+// checkWindow makes sure a scheduled discount ends after it starts.
+func checkWindow(discount models.Discount) error {
+	if discount.StartsAt != nil && discount.EndsAt != nil && !discount.EndsAt.After(*discount.StartsAt) {
+		return fmt.Errorf("endsAt must be after startsAt")
 	}
+	return nil
+}
 
-	if discount.TargetType == "product" {
-		filter := bson.M{"_id": discount.TargetID}
+// checkVariant makes sure a variant discount targets an existing variant of
+// the discounted product.
+func (dc *DiscountController) checkVariant(ctx context.Context, discount models.Discount) error {
+	if discount.VariantID == nil {
+		return nil
+	}
+	if discount.TargetType != models.DiscountProduct {
+		return fmt.Errorf("variantId requires targetType product")
+	}
 
-		var product models.Product
-		err := dc.Products.FindOne(ctx, filter).Decode(&product)
-		if err != nil {
-			return fmt.Errorf("product not found: %w", err)
+	count, err := dc.Products.CountDocuments(ctx, bson.M{"_id": discount.TargetID, "variants.id": *discount.VariantID})
+	if err != nil {
+		return fmt.Errorf("failed to check variant: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("variant not found on product")
+	}
+	return nil
+}
+
+// checkRule validates the fields each target type needs.
+func (dc *DiscountController) checkRule(ctx context.Context, discount models.Discount) error {
+	switch discount.TargetType {
+	case models.DiscountTag:
+		if strings.TrimSpace(discount.Tag) == "" {
+			return fmt.Errorf("tag is required")
 		}
+		if !discount.TargetID.IsZero() || discount.VariantID != nil {
+			return fmt.Errorf("a tag discount takes no targetId or variantId")
+		}
+		return checkPercentage(discount.Percentage)
 
-		if product.OriginalPrice == nil || (product.OriginalPrice != nil && *product.OriginalPrice == 0.00) {
-			originalPrice := product.Price
-			newPrice := newPriceFunc(originalPrice, discount.Percentage)
-			update := bson.M{"$set": bson.M{"price": newPrice, "originalPrice": &originalPrice}}
-			_, err = dc.Products.UpdateOne(ctx, filter, update)
-			if err != nil {
-				return fmt.Errorf("failed to update product price: %w", err)
-			}
+	case models.DiscountCart:
+		if !discount.TargetID.IsZero() || discount.VariantID != nil {
+			return fmt.Errorf("a cart discount takes no targetId or variantId")
 		}
-	} else if discount.TargetType == "collection" {
-		var collection models.Collection
-		err := dc.Collections.FindOne(ctx, bson.M{"_id": discount.TargetID}).Decode(&collection)
-		if err != nil {
-			return fmt.Errorf("collection not found: %w", err)
+		if (discount.Percentage > 0) == (discount.AmountOff > 0) {
+			return fmt.Errorf("a cart discount needs either a percentage or an amountOff")
 		}
+		if discount.AmountOff < 0 || discount.MinSubtotal < 0 {
+			return fmt.Errorf("amountOff and minSubtotal cannot be negative")
+		}
+		if discount.Percentage > 0 {
+			return checkPercentage(discount.Percentage)
+		}
+		return nil
 
-		for _, productID := range collection.ProductIds {
-			var product models.Product
-			err := dc.Products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
-			if err != nil {
-				fmt.Printf("Product %s not found in collection: %v\n", productID.Hex(), err)
-				continue
+	case models.DiscountQuantityTier:
+		if err := dc.checkProductTarget(ctx, discount); err != nil {
+			return err
+		}
+		if len(discount.Tiers) == 0 {
+			return fmt.Errorf("tiers are required")
+		}
+		for i, tier := range discount.Tiers {
+			if tier.MinQuantity < 2 {
+				return fmt.Errorf("tier minQuantity must be at least 2")
 			}
-
-			if product.OriginalPrice == nil || *product.OriginalPrice == 0 {
-				originalPrice := product.Price
-				newPrice := newPriceFunc(originalPrice, discount.Percentage)
-
-				update := bson.M{
-					"$set": bson.M{
-						"price":         newPrice,
-						"originalPrice": &originalPrice,
-						"updatedAt":     time.Now(),
-					},
-				}
-
-				_, err := dc.Products.UpdateByID(ctx, productID, update)
-				if err != nil {
-					fmt.Printf("Failed to update product %s: %v\n", productID.Hex(), err)
-				}
+			if i > 0 && tier.MinQuantity <= discount.Tiers[i-1].MinQuantity {
+				return fmt.Errorf("tiers must be in increasing minQuantity order")
+			}
+			if err := checkPercentage(tier.Percentage); err != nil {
+				return err
 			}
 		}
-	}
-	return nil
-}
+		return nil
 
-// revertDiscount logic: restores the original price and removes the originalPrice field
-func (dc *DiscountController) revertDiscount(ctx context.Context, discountID primitive.ObjectID) error {
-	var discount models.Discount
-	err := dc.Discounts.FindOne(ctx, bson.M{"_id": discountID}).Decode(&discount)
-	if err != nil {
-		return err
-	}
-
-	if discount.TargetType == "product" {
-		var product models.Product
-		err := dc.Products.FindOne(ctx, bson.M{"_id": discount.TargetID}).Decode(&product)
-		if err != nil {
+	case models.DiscountBuyXGetY:
+		if err := dc.checkProductTarget(ctx, discount); err != nil {
 			return err
 		}
+		if discount.BuyQuantity < 1 || discount.GetQuantity < 1 {
+			return fmt.Errorf("buyQuantity and getQuantity must be at least 1")
+		}
+		return checkPercentage(discount.Percentage)
 
-		if product.OriginalPrice != nil {
-			update := bson.M{
-				"$set": bson.M{
-					"price":     *product.OriginalPrice,
-					"updatedAt": time.Now(),
-				},
-				"$unset": bson.M{
-					"originalPrice": "",
-				},
-			}
-
-			_, err := dc.Products.UpdateByID(ctx, discount.TargetID, update)
-			if err != nil {
-				return err
-			}
+	case models.DiscountProduct:
+		if err := checkPercentage(discount.Percentage); err != nil {
+			return err
 		}
-	}
+		if err := dc.checkProductExists(ctx, discount); err != nil {
+			return err
+		}
+		return dc.checkVariant(ctx, discount)
 
-	if discount.TargetType == "collection" {
-		var collection models.Collection
-		err := dc.Collections.FindOne(ctx, bson.M{"_id": discount.TargetID}).Decode(&collection)
+	case models.DiscountCollection:
+		if err := checkPercentage(discount.Percentage); err != nil {
+			return err
+		}
+		if discount.VariantID != nil {
+			return fmt.Errorf("variantId requires targetType product")
+		}
+		count, err := dc.Collections.CountDocuments(ctx, bson.M{"_id": discount.TargetID})
 		if err != nil {
-			return fmt.Errorf("collection not found: %w", err)
+			return fmt.Errorf("failed to check collection: %w", err)
 		}
+		if count == 0 {
+			return fmt.Errorf("collection not found")
+		}
+		return nil
 
-		for _, productID := range collection.ProductIds {
-			var product models.Product
-			err := dc.Products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product)
-			if err != nil {
-				fmt.Printf("Error finding product %s: %v\n", productID.Hex(), err)
-				continue
-			}
+	default:
+		return fmt.Errorf("targetType must be one of product, collection, tag, cart, quantity_tier or bxgy")
+	}
+}
 
-			if product.OriginalPrice != nil {
-				update := bson.M{
-					"$set": bson.M{
-						"price":     *product.OriginalPrice,
-						"updatedAt": time.Now(),
-					},
-					"$unset": bson.M{
-						"originalPrice": "",
-					},
-				}
-
-				_, err := dc.Products.UpdateByID(ctx, productID, update)
-				if err != nil {
-					fmt.Printf("Failed to revert product %s: %v\n", productID.Hex(), err)
-				}
-			}
-		}
+// decodeDiscount reads a discount from a request body, rejecting fields a
+// discount does not have so a misspelt rule is not saved half-applied.
+func decodeDiscount(r *http.Request, discount *models.Discount) error {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(discount)
+}
+
+// checkPercentage makes sure a percentage off is above 0 and at most 100.
+func checkPercentage(percentage float64) error {
+	if percentage <= 0 || percentage > 100 {
+		return fmt.Errorf("percentage must be above 0 and at most 100")
 	}
+	return nil
+}
 
+// checkProductTarget makes sure a quantity tier or buy-X-get-Y discount
+// targets an existing product as a whole.
+func (dc *DiscountController) checkProductTarget(ctx context.Context, discount models.Discount) error {
+	if discount.VariantID != nil {
+		return fmt.Errorf("variantId requires targetType product")
+	}
+	return dc.checkProductExists(ctx, discount)
+}
+
+// checkProductExists makes sure the product a discount targets exists.
+func (dc *DiscountController) checkProductExists(ctx context.Context, discount models.Discount) error {
+	count, err := dc.Products.CountDocuments(ctx, bson.M{"_id": discount.TargetID})
+	if err != nil {
+		return fmt.Errorf("failed to check product: %w", err)
+	}
+	if count == 0 {
+		return fmt.Errorf("product not found")
+	}
 	return nil
 }
 
@@ -167,21 +188,28 @@ func (dc *DiscountController) CreateDiscount(w http.ResponseWriter, r *http.Requ
 	w.Header().Set("Content-Type", "application/json")
 
 	var discount models.Discount
-	if err := json.NewDecoder(r.Body).Decode(&discount); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeDiscount(r, &discount); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	if discount.TargetType == "" || discount.TargetID.IsZero() || discount.Percentage <= 0 {
-		http.Error(w, "Missing or invalid fields", http.StatusBadRequest)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := checkWindow(discount); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := dc.checkRule(ctx, discount); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	discount.ID = primitive.NilObjectID // Always generated
 	discount.CreatedAt = time.Now()
 	discount.UpdatedAt = time.Now()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	discount.Version = 1
+	discount.State = discount.StateAt(discount.CreatedAt)
 
 	res, err := dc.Discounts.InsertOne(ctx, discount)
 	if err != nil {
@@ -191,16 +219,19 @@ func (dc *DiscountController) CreateDiscount(w http.ResponseWriter, r *http.Requ
 
 	discount.ID = res.InsertedID.(primitive.ObjectID)
 
-	// Apply discount logic
-	if err := dc.applyDiscount(ctx, discount); err != nil {
-		fmt.Printf("Warning: Failed to apply discount: %v\n", err)
-	}
-
+	setVersionETag(w, discount.Version)
 	json.NewEncoder(w).Encode(discount)
 }
 
-// GetDiscounts handles GET /discounts
+// GetDiscounts handles GET /discounts and GET /discounts?state=upcoming,
+// active or expired
 func (dc *DiscountController) GetDiscounts(w http.ResponseWriter, r *http.Request) {
+	state := r.URL.Query().Get("state")
+	if state != "" && state != models.DiscountUpcoming && state != models.DiscountActive && state != models.DiscountExpired {
+		http.Error(w, "state must be upcoming, active or expired", http.StatusBadRequest)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
@@ -217,8 +248,41 @@ func (dc *DiscountController) GetDiscounts(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// States follow the clock, so they are worked out on every read
+	now := time.Now()
+	filtered := []models.Discount{}
+	for _, discount := range discounts {
+		discount.State = discount.StateAt(now)
+		if state == "" || discount.State == state {
+			filtered = append(filtered, discount)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered)
+}
+
+// GetDiscount handles GET /discounts/{id}
+func (dc *DiscountController) GetDiscount(w http.ResponseWriter, r *http.Request) {
+	discountID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var discount models.Discount
+	if err := dc.Discounts.FindOne(ctx, bson.M{"_id": discountID}).Decode(&discount); err != nil {
+		http.Error(w, "Discount not found", http.StatusNotFound)
+		return
+	}
+	discount.State = discount.StateAt(time.Now())
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(discounts)
+	setVersionETag(w, discount.Version)
+	json.NewEncoder(w).Encode(discount)
 }
 
 // UpdateDiscount handles PUT /discounts/{id}
@@ -230,40 +294,67 @@ func (dc *DiscountController) UpdateDiscount(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var updated models.Discount
-	if err := json.NewDecoder(r.Body).Decode(&updated); err != nil {
-		http.Error(w, "Invalid input", http.StatusBadRequest)
+	if err := decodeDiscount(r, &updated); err != nil {
+		http.Error(w, "Invalid input: "+err.Error(), http.StatusBadRequest)
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := dc.revertDiscount(ctx, discountID); err != nil {
-		fmt.Printf("Error reverting old discount: %v\n", err)
-		http.Error(w, "Failed to revert old discount", http.StatusInternalServerError)
+	if err := checkWindow(updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := dc.checkRule(ctx, updated); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var current models.Discount
+	if err := dc.Discounts.FindOne(ctx, bson.M{"_id": discountID}).Decode(&current); err != nil {
+		http.Error(w, "Discount not found", http.StatusNotFound)
+		return
+	}
+	if current.Version != ifMatch {
+		writeVersionConflict(w)
 		return
 	}
 
 	update := bson.M{
 		"$set": bson.M{
-			"targetType": updated.TargetType,
-			"targetId":   updated.TargetID,
-			"percentage": updated.Percentage,
-			"updatedAt":  time.Now(),
+			"targetType":  updated.TargetType,
+			"targetId":    updated.TargetID,
+			"variantId":   updated.VariantID,
+			"percentage":  updated.Percentage,
+			"tag":         updated.Tag,
+			"amountOff":   updated.AmountOff,
+			"minSubtotal": updated.MinSubtotal,
+			"tiers":       updated.Tiers,
+			"buyQuantity": updated.BuyQuantity,
+			"getQuantity": updated.GetQuantity,
+			"startsAt":    updated.StartsAt,
+			"endsAt":      updated.EndsAt,
+			"updatedAt":   time.Now(),
 		},
 	}
-	_, err = dc.Discounts.UpdateOne(ctx, bson.M{"_id": discountID}, update)
+	res, err := dc.Discounts.UpdateOne(ctx, bson.M{"_id": discountID, "version": ifMatch}, bumpVersion(update))
 	if err != nil {
 		http.Error(w, "Failed to update discount", http.StatusInternalServerError)
 		return
 	}
-
-	updated.ID = discountID
-	if err := dc.applyDiscount(ctx, updated); err != nil {
-		fmt.Printf("Error applying new discount: %v\n", err)
+	if res.MatchedCount == 0 {
+		writeVersionConflict(w)
+		return
 	}
 
+	setVersionETag(w, ifMatch+1)
 	w.WriteHeader(http.StatusOK)
 }
 
@@ -279,12 +370,6 @@ func (dc *DiscountController) DeleteDiscount(w http.ResponseWriter, r *http.Requ
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := dc.revertDiscount(ctx, discountID); err != nil {
-		fmt.Printf("Error reverting discount: %v\n", err)
-		http.Error(w, "Failed to revert discount price", http.StatusInternalServerError)
-		return
-	}
-
 	res, err := dc.Discounts.DeleteOne(ctx, bson.M{"_id": discountID})
 	if err != nil {
 		http.Error(w, "Failed to delete discount", http.StatusInternalServerError)
@@ -0,0 +1,155 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// previewCartLimit caps how many carts a preview prices.
+const previewCartLimit = 500
+
+// previewProduct is a product whose price a discount would change.
+type previewProduct struct {
+	ID          string  `json:"id"`
+	Sku         string  `json:"sku"`
+	Title       string  `json:"title"`
+	Price       float64 `json:"price"`       // Catalog price
+	PriceBefore float64 `json:"priceBefore"` // With the current discounts
+	PriceAfter  float64 `json:"priceAfter"`  // With the previewed discount added
+}
+
+// previewCarts sums up how a discount would change the saved carts.
+type previewCarts struct {
+	Checked  int     `json:"checked"`
+	Affected int     `json:"affected"`
+	Savings  float64 `json:"savings"` // Taken off the affected carts together
+}
+
+// discountPreview is what a discount would do if it were active now.
+type discountPreview struct {
+	Products []previewProduct `json:"products"` // Shown prices; empty for cart discounts
+	Carts    previewCarts     `json:"carts"`
+}
+
+// PreviewDiscount handles POST /discounts/preview. The discount in the body
+// is validated like CreateDiscount and priced against the catalog and the
+// saved carts, on top of the discounts already active, without being saved.
+func (dc *DiscountController) PreviewDiscount(w http.ResponseWriter, r *http.Request) {
+	var discount models.Discount
+	if err := decodeDiscount(r, &discount); err != nil {
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	if err := checkWindow(discount); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := dc.checkRule(ctx, discount); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preview, err := dc.previewDiscount(ctx, discount)
+	if err != nil {
+		fmt.Println("Error previewing discount:", err)
+		http.Error(w, "Failed to preview discount", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(preview)
+}
+
+// previewDiscount prices the catalog and the carts with and without discount.
+func (dc *DiscountController) previewDiscount(ctx context.Context, discount models.Discount) (discountPreview, error) {
+	preview := discountPreview{Products: []previewProduct{}}
+	db := dc.Discounts.Database()
+
+	before, err := loadPriceBook(ctx, db)
+	if err != nil {
+		return preview, err
+	}
+	after, err := loadPriceBook(ctx, db)
+	if err != nil {
+		return preview, err
+	}
+	if err := after.add(ctx, db, discount); err != nil {
+		return preview, err
+	}
+
+	// Products the rule reaches; cart discounts reach none in particular
+	var filter bson.M
+	switch discount.TargetType {
+	case models.DiscountProduct, models.DiscountQuantityTier, models.DiscountBuyXGetY:
+		filter = bson.M{"_id": discount.TargetID}
+	case models.DiscountCollection:
+		var collection models.Collection
+		if err := dc.Collections.FindOne(ctx, bson.M{"_id": discount.TargetID}).Decode(&collection); err == nil {
+			filter = bson.M{"_id": bson.M{"$in": collection.ProductIds}}
+		}
+	case models.DiscountTag:
+		filter = bson.M{"tag": discount.Tag}
+	}
+	if filter != nil {
+		filter["status"] = models.ProductActive
+		cursor, err := dc.Products.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "sku", Value: 1}}))
+		if err != nil {
+			return preview, fmt.Errorf("failed to load products: %w", err)
+		}
+		var products []models.Product
+		if err := cursor.All(ctx, &products); err != nil {
+			return preview, fmt.Errorf("failed to decode products: %w", err)
+		}
+		for _, product := range products {
+			preview.Products = append(preview.Products, previewProduct{
+				ID:          product.ID.Hex(),
+				Sku:         product.Sku,
+				Title:       product.Title,
+				Price:       product.Price,
+				PriceBefore: before.price(product).Price,
+				PriceAfter:  after.price(product).Price,
+			})
+		}
+	}
+
+	// The most recently created carts that hold something
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(previewCartLimit)
+	cursor, err := db.Collection("carts").Find(ctx, bson.M{"items.0": bson.M{"$exists": true}}, opts)
+	if err != nil {
+		return preview, fmt.Errorf("failed to load carts: %w", err)
+	}
+	var carts []models.Cart
+	if err := cursor.All(ctx, &carts); err != nil {
+		return preview, fmt.Errorf("failed to decode carts: %w", err)
+	}
+
+	for _, cart := range carts {
+		current, err := priceCart(ctx, db, before, cart.Items)
+		if err != nil {
+			continue // Carts that could not be checked out as they are
+		}
+		preview.Carts.Checked++
+
+		changed, err := priceCart(ctx, db, after, cart.Items)
+		if err != nil || changed.Total >= current.Total {
+			continue
+		}
+		preview.Carts.Affected++
+		preview.Carts.Savings += current.Total - changed.Total
+	}
+	preview.Carts.Savings = roundPrice(preview.Carts.Savings)
+
+	return preview, nil
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Failed-login limits. Once a key reaches its threshold it is locked for
+// baseLockout, doubling with every further failure up to maxLockout.
+// Counters expire a day after the last failure (TTL index on login_attempts).
+const (
+	maxAccountFailures = 5
+	maxIPFailures      = 20
+	baseLockout        = time.Minute
+	maxLockout         = time.Hour
+)
+
+// Password reset requests are counted the same way, under their own keys so
+// they never lock anyone out of logging in.
+const (
+	maxResetRequestsPerEmail = 3
+	maxResetRequestsPerIP    = 10
+)
+
+func accountAttemptKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipAttemptKey(ip string) string {
+	return "ip:" + ip
+}
+
+func resetAccountKey(email string) string {
+	return "reset-email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func resetIPKey(ip string) string {
+	return "reset-ip:" + ip
+}
+
+// trustedProxy reports whether ip is one of the proxies in TRUSTED_PROXIES,
+// a comma-separated list of addresses or CIDR ranges.
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, entry := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if network.Contains(addr) {
+				return true
+			}
+		} else if proxy := net.ParseIP(entry); proxy != nil && proxy.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the caller's address. X-Forwarded-For is only read when
+// the request comes from a trusted proxy, since anyone else can set it; the
+// client is then the last entry that is not itself a trusted proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip == "" {
+			continue
+		}
+		if !trustedProxy(ip) {
+			return ip
+		}
+		host = ip
+	}
+	return host
+}
+
+// lockoutRemaining returns how long the longest active lock among keys
+// still lasts, or zero when none of them is locked.
+func lockoutRemaining(ctx context.Context, db *mongo.Database, keys ...string) (time.Duration, error) {
+	cursor, err := db.Collection("login_attempts").Find(ctx, bson.M{
+		"_id":         bson.M{"$in": keys},
+		"lockedUntil": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []models.LoginAttempt
+	if err := cursor.All(ctx, &attempts); err != nil {
+		return 0, err
+	}
+
+	var remaining time.Duration
+	for _, attempt := range attempts {
+		if left := time.Until(*attempt.LockedUntil); left > remaining {
+			remaining = left
+		}
+	}
+	return remaining, nil
+}
+
+// recordLoginFailure counts a failed attempt against key and locks it once
+// threshold is reached.
+func recordLoginFailure(ctx context.Context, db *mongo.Database, key string, threshold int, email string, ip string) {
+	failures, lockedUntil := countAttempt(ctx, db, key, threshold)
+	if lockedUntil == nil {
+		return
+	}
+
+	recordAuditEvent(ctx, db, models.AuditEvent{
+		Type:  models.AuditLoginLocked,
+		Email: strings.ToLower(strings.TrimSpace(email)),
+		IP:    ip,
+		Details: map[string]interface{}{
+			"key":         key,
+			"failures":    failures,
+			"lockedUntil": *lockedUntil,
+		},
+	})
+}
+
+// countAttempt counts an attempt against key and, once threshold is reached,
+// locks it. It returns the attempts counted so far and, when it locked the
+// key, until when.
+func countAttempt(ctx context.Context, db *mongo.Database, key string, threshold int) (int, *time.Time) {
+	attempts := db.Collection("login_attempts")
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	update := bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailureAt": time.Now()},
+	}
+
+	var attempt models.LoginAttempt
+	if err := attempts.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt); err != nil {
+		fmt.Printf("Warning: failed to record attempt for %s: %v\n", key, err)
+		return 0, nil
+	}
+
+	if attempt.Failures < threshold {
+		return attempt.Failures, nil
+	}
+
+	lockout := time.Duration(float64(baseLockout) * math.Pow(2, float64(attempt.Failures-threshold)))
+	if lockout > maxLockout || lockout <= 0 {
+		lockout = maxLockout
+	}
+	lockedUntil := time.Now().Add(lockout)
+
+	if _, err := attempts.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}}); err != nil {
+		fmt.Printf("Warning: failed to lock %s: %v\n", key, err)
+		return attempt.Failures, nil
+	}
+	return attempt.Failures, &lockedUntil
+}
+
+// clearLoginFailures resets the counter for key after a successful login or
+// an admin unlock, auditing the unlock if the key had been locked.
+func clearLoginFailures(ctx context.Context, db *mongo.Database, key string, email string, ip string, actorID string, reason string) error {
+	var attempt models.LoginAttempt
+	err := db.Collection("login_attempts").FindOneAndDelete(ctx, bson.M{"_id": key}).Decode(&attempt)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if attempt.LockedUntil != nil {
+		recordAuditEvent(ctx, db, models.AuditEvent{
+			Type:    models.AuditLoginUnlocked,
+			Email:   strings.ToLower(strings.TrimSpace(email)),
+			IP:      ip,
+			ActorID: actorID,
+			Details: map[string]interface{}{
+				"key":      key,
+				"failures": attempt.Failures,
+				"reason":   reason,
+			},
+		})
+	}
+	return nil
+}
+
+// UnlockLogin handles POST /admin/login-lockouts/unlock. It clears the
+// failure counters of an account email and/or a client IP.
+func UnlockLogin(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		Email string `json:"email"`
+		IP    string `json:"ip"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || (body.Email == "" && body.IP == "") {
+		http.Error(w, "email or ip is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	actorID := utils.UserIDFromContext(r.Context())
+	if body.Email != "" {
+		if err := clearLoginFailures(ctx, db, accountAttemptKey(body.Email), body.Email, "", actorID, "admin"); err != nil {
+			http.Error(w, "Failed to unlock account", http.StatusInternalServerError)
+			return
+		}
+	}
+	if body.IP != "" {
+		if err := clearLoginFailures(ctx, db, ipAttemptKey(body.IP), "", body.IP, actorID, "admin"); err != nil {
+			http.Error(w, "Failed to unlock IP", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Unlocked"})
+}
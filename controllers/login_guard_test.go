@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.1, 192.168.0.0/16")
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"direct", "203.0.113.7:4000", "", "203.0.113.7"},
+		{"direct with a spoofed header", "203.0.113.7:4000", "198.51.100.1", "203.0.113.7"},
+		{"through a trusted proxy", "10.0.0.1:4000", "198.51.100.1", "198.51.100.1"},
+		{"client entries before the proxy are ignored", "10.0.0.1:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"through a chain of trusted proxies", "10.0.0.1:4000", "198.51.100.1, 192.168.4.2", "198.51.100.1"},
+		{"trusted proxy without a header", "10.0.0.1:4000", "", "10.0.0.1"},
+		{"untrusted neighbour", "10.0.0.2:4000", "198.51.100.1", "10.0.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPWithoutTrustedProxies(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "")
+
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := clientIP(r); got != "10.0.0.1" {
+		t.Errorf("clientIP = %q, want the remote address", got)
+	}
+}
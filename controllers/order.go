@@ -5,40 +5,34 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"time"
 
 	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// CreateOrder handles POST /orders/{userId}. Only the contact details of
+// the request body are used; items and prices come from the user's cart.
 func CreateOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
-	userID := mux.Vars(r)["userId"]
-
-	var order models.Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, mux.Vars(r)["userId"])
+	if !ok {
 		return
 	}
 
-	order.ID = primitive.NewObjectID()
-	order.OrderID = fmt.Sprintf("OS%d", time.Now().Unix())
-	order.CreatedAt = time.Now()
-	order.UserID = userID
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	if _, err := db.Collection("orders").InsertOne(ctx, order); err != nil {
-		http.Error(w, "Error creating order", http.StatusInternalServerError)
+	var req checkoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(order)
+	writeCheckoutResult(w, r, db, userID, req)
 }
 
 func GetOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
@@ -60,29 +54,135 @@ func GetOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
+	// Customers may only read their own orders
+	if order.UserID != utils.UserIDFromContext(r.Context()) && !isStaffRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
 	json.NewEncoder(w).Encode(order)
 }
 
+// GetAllOrders handles GET /orders?userId=&status=&from=&to=&page=&limit=
 func GetAllOrders(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	filter, err := orderFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if userID := r.URL.Query().Get("userId"); userID != "" {
+		filter["userId"] = userID
+	}
+
+	listOrders(w, r, db, filter)
+}
+
+// GetMyOrders handles GET /me/orders?status=&from=&to=&page=&limit=
+func GetMyOrders(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	filter, err := orderFilterFromQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	filter["userId"] = utils.UserIDFromContext(r.Context())
+
+	listOrders(w, r, db, filter)
+}
+
+// orderFilterFromQuery builds an orders filter from the ?status=, ?from= and
+// ?to= parameters. Dates are RFC 3339 timestamps or YYYY-MM-DD days; a bare
+// ?to= day includes the whole day.
+func orderFilterFromQuery(query url.Values) (bson.M, error) {
+	filter := bson.M{}
+
+	if status := query.Get("status"); status != "" {
+		if !isOrderStatus(status) {
+			return nil, fmt.Errorf("unknown order status")
+		}
+		filter["status"] = status
+	}
+
+	created := bson.M{}
+	if raw := query.Get("from"); raw != "" {
+		from, _, err := parseDateParam(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid from date")
+		}
+		created["$gte"] = from
+	}
+	if raw := query.Get("to"); raw != "" {
+		to, dayOnly, err := parseDateParam(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to date")
+		}
+		if dayOnly {
+			to = to.AddDate(0, 0, 1)
+			created["$lt"] = to
+		} else {
+			created["$lte"] = to
+		}
+	}
+	if len(created) > 0 {
+		filter["creationDate"] = created
+	}
+
+	return filter, nil
+}
+
+// parseDateParam parses an RFC 3339 timestamp or a YYYY-MM-DD day, reporting
+// which of the two it was.
+func parseDateParam(raw string) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, false, nil
+	}
+	t, err := time.Parse("2006-01-02", raw)
+	return t, true, err
+}
+
+// listOrders writes one page of orders matching filter, newest first.
+func listOrders(w http.ResponseWriter, r *http.Request, db *mongo.Database, filter bson.M) {
+	page, err := parsePagination(r, 20, 100)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := db.Collection("orders").Find(ctx, bson.M{})
+	collection := db.Collection("orders")
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		http.Error(w, "Failed to count orders", http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "creationDate", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(page.skip()).
+		SetLimit(page.Limit)
+	cursor, err := collection.Find(ctx, filter, opts)
 	if err != nil {
 		http.Error(w, "Failed to retrieve orders", http.StatusInternalServerError)
 		return
 	}
 	defer cursor.Close(ctx)
 
-	var orders []models.Order
+	orders := []models.Order{}
 	if err := cursor.All(ctx, &orders); err != nil {
 		http.Error(w, "Error parsing orders", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(orders)
+	json.NewEncoder(w).Encode(pageResponse(orders, page, total))
 }
 
+// UpdateOrder handles PUT /orders/{orderId}. Only the delivery contact
+// details can be edited; items and prices are fixed at checkout and the
+// status changes through POST /orders/{orderId}/status.
 func UpdateOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	orderID := mux.Vars(r)["orderId"]
 
@@ -101,23 +201,27 @@ func UpdateOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"items":       updatedOrder.Items,
-			"total":       updatedOrder.Total,
-			"subtotal":    updatedOrder.Subtotal,
-			"userId":      updatedOrder.UserID,
-			"createdAt":   updatedOrder.CreatedAt,
-			"location":    updatedOrder.Location,
-			"hasDiscount": updatedOrder.Discounted,
-		},
+	fields := bson.M{}
+	if updatedOrder.PhoneNumber != "" {
+		fields["phoneNumber"] = updatedOrder.PhoneNumber
+	}
+	if updatedOrder.Location != "" {
+		fields["userLocation"] = updatedOrder.Location
+	}
+	if len(fields) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
 	}
 
-	_, err = db.Collection("orders").UpdateOne(ctx, bson.M{"_id": objID}, update)
+	res, err := db.Collection("orders").UpdateOne(ctx, bson.M{"_id": objID}, bson.M{"$set": fields})
 	if err != nil {
 		http.Error(w, "Error updating order", http.StatusInternalServerError)
 		return
 	}
+	if res.MatchedCount == 0 {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(bson.M{"message": "Order updated successfully"})
@@ -135,12 +239,49 @@ func DeleteOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
-	_, err = db.Collection("orders").DeleteOne(ctx, bson.M{"_id": objID})
-	if err != nil {
+	var order models.Order
+	err = db.Collection("orders").FindOneAndDelete(ctx, bson.M{"_id": objID}).Decode(&order)
+	if err == mongo.ErrNoDocuments {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "Error deleting order", http.StatusInternalServerError)
 		return
 	}
 
+	if orderHoldsStock(order) {
+		restoreStock(ctx, db, order.Items, order.OrderID, models.StockOrderDeleted)
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(bson.M{"message": "Order deleted successfully"})
 }
+
+// CancelOrder handles POST /orders/{orderId}/cancel. Customers can cancel
+// their own order until it is packed; staff follow the normal transitions.
+func CancelOrder(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["orderId"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var order models.Order
+	if err := db.Collection("orders").FindOne(r.Context(), bson.M{"_id": objID}).Decode(&order); err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	if !isStaffRequest(r) {
+		if order.UserID != utils.UserIDFromContext(r.Context()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !customerCancellable[order.Status] {
+			http.Error(w, "Order can no longer be cancelled", http.StatusConflict)
+			return
+		}
+	}
+
+	writeTransitionResult(w, r, db, &order, models.OrderCancelled, "")
+}
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// nextOrderNumber returns the next public order number, e.g. OS-2026-000123.
+// Numbers come from a per-year counter that is incremented atomically, so
+// concurrent checkouts never share a number.
+func nextOrderNumber(ctx context.Context, db *mongo.Database) (string, error) {
+	year := time.Now().Year()
+	counterID := fmt.Sprintf("orders-%d", year)
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		err = db.Collection("counters").FindOneAndUpdate(ctx,
+			bson.M{"_id": counterID},
+			bson.M{"$inc": bson.M{"seq": 1}},
+			opts,
+		).Decode(&counter)
+		// Two upserts racing to create the year's counter: retry the loser
+		if !mongo.IsDuplicateKeyError(err) {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate order number: %w", err)
+	}
+
+	return fmt.Sprintf("OS-%d-%06d", year, counter.Seq), nil
+}
+
+// GetOrderByNumber handles GET /orders/number/{orderNumber}
+func GetOrderByNumber(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	orderNumber := strings.ToUpper(strings.TrimSpace(mux.Vars(r)["orderNumber"]))
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var order models.Order
+	if err := db.Collection("orders").FindOne(ctx, bson.M{"orderId": orderNumber}).Decode(&order); err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	// Customers may only read their own orders
+	if order.UserID != utils.UserIDFromContext(r.Context()) && !isStaffRequest(r) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	json.NewEncoder(w).Encode(order)
+}
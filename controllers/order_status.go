@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// orderTransitions lists the statuses an order may move to from each status.
+// Delivered orders can come back as returns, as can shipments the customer
+// refused. Cancelled and returned are final.
+var orderTransitions = map[string][]string{
+	models.OrderPending:   {models.OrderConfirmed, models.OrderCancelled},
+	models.OrderConfirmed: {models.OrderPacked, models.OrderCancelled},
+	models.OrderPacked:    {models.OrderShipped, models.OrderCancelled},
+	models.OrderShipped:   {models.OrderDelivered, models.OrderReturned},
+	models.OrderDelivered: {models.OrderReturned},
+}
+
+// customerCancellable are the statuses in which customers may still cancel
+// their own order.
+var customerCancellable = map[string]bool{
+	models.OrderPending:   true,
+	models.OrderConfirmed: true,
+}
+
+func isOrderStatus(status string) bool {
+	_, hasNext := orderTransitions[status]
+	return hasNext || status == models.OrderCancelled || status == models.OrderReturned
+}
+
+func canTransition(from, to string) bool {
+	for _, allowed := range orderTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// transitionOrder moves order to status if the state machine allows it. The
+// update only succeeds if the order is still in the status it was read in, so
+// concurrent transitions cannot both win. Cancelling or returning an order
+// puts back the stock its checkout reserved.
+func transitionOrder(ctx context.Context, db *mongo.Database, order *models.Order, status string, actorID string, note string) error {
+	if !canTransition(order.Status, status) {
+		return &statusError{http.StatusConflict, fmt.Sprintf("Cannot move order from %s to %s", order.Status, status)}
+	}
+
+	change := models.OrderStatusChange{Status: status, At: time.Now(), ActorID: actorID, Note: note}
+	set := bson.M{"status": status}
+	if status == models.OrderCancelled {
+		set["cancelledAt"] = change.At
+	}
+
+	res, err := db.Collection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "status": order.Status},
+		bson.M{"$set": set, "$push": bson.M{"statusHistory": change}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return &statusError{http.StatusConflict, "Order status changed, reload and try again"}
+	}
+
+	order.Status = status
+	order.StatusHistory = append(order.StatusHistory, change)
+	if status == models.OrderCancelled {
+		order.CancelledAt = &change.At
+	}
+
+	switch status {
+	case models.OrderCancelled:
+		if err := releaseOrderStock(ctx, db, *order, models.StockOrderCancelled); err != nil {
+			return err
+		}
+		order.StockReleased = order.StockReserved
+		if err := releaseOrderCoupon(ctx, db, *order); err != nil {
+			return err
+		}
+		order.CouponReleased = order.CouponCode != ""
+	case models.OrderReturned:
+		if err := releaseOrderStock(ctx, db, *order, models.StockOrderReturned); err != nil {
+			return err
+		}
+		order.StockReleased = order.StockReserved
+	}
+	return nil
+}
+
+// writeTransitionResult applies a transition and writes the updated order,
+// or the failure, to w.
+func writeTransitionResult(w http.ResponseWriter, r *http.Request, db *mongo.Database, order *models.Order, status string, note string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := transitionOrder(ctx, db, order, status, utils.UserIDFromContext(r.Context()), note); err != nil {
+		if statusErr, ok := err.(*statusError); ok {
+			http.Error(w, statusErr.message, statusErr.status)
+			return
+		}
+		fmt.Println("Error changing order status:", err)
+		http.Error(w, "Error updating order status", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(order)
+}
+
+// UpdateOrderStatus handles POST /orders/{orderId}/status
+func UpdateOrderStatus(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["orderId"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+
+	var body struct {
+		Status string `json:"status"`
+		Note   string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	if !isOrderStatus(body.Status) {
+		http.Error(w, "Unknown order status", http.StatusBadRequest)
+		return
+	}
+
+	var order models.Order
+	if err := db.Collection("orders").FindOne(r.Context(), bson.M{"_id": objID}).Decode(&order); err != nil {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+
+	writeTransitionResult(w, r, db, &order, body.Status, body.Note)
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"testing"
+
+	"oldsouqs-backend/models"
+)
+
+func TestCanTransition(t *testing.T) {
+	statuses := []string{
+		models.OrderPending,
+		models.OrderConfirmed,
+		models.OrderPacked,
+		models.OrderShipped,
+		models.OrderDelivered,
+		models.OrderCancelled,
+		models.OrderReturned,
+	}
+
+	allowed := map[[2]string]bool{
+		{models.OrderPending, models.OrderConfirmed}:   true,
+		{models.OrderPending, models.OrderCancelled}:   true,
+		{models.OrderConfirmed, models.OrderPacked}:    true,
+		{models.OrderConfirmed, models.OrderCancelled}: true,
+		{models.OrderPacked, models.OrderShipped}:      true,
+		{models.OrderPacked, models.OrderCancelled}:    true,
+		{models.OrderShipped, models.OrderDelivered}:   true,
+		{models.OrderShipped, models.OrderReturned}:    true,
+		{models.OrderDelivered, models.OrderReturned}:  true,
+	}
+
+	for _, from := range statuses {
+		for _, to := range statuses {
+			want := allowed[[2]string{from, to}]
+			if got := canTransition(from, to); got != want {
+				t.Errorf("canTransition(%s, %s) = %v, want %v", from, to, got, want)
+			}
+		}
+	}
+
+	if canTransition("", models.OrderConfirmed) {
+		t.Errorf("an order without a status must not move")
+	}
+}
+
+func TestIsOrderStatus(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{models.OrderPending, true},
+		{models.OrderConfirmed, true},
+		{models.OrderPacked, true},
+		{models.OrderShipped, true},
+		{models.OrderDelivered, true},
+		{models.OrderCancelled, true},
+		{models.OrderReturned, true},
+		{"", false},
+		{"refunded", false},
+		{"Pending", false},
+	}
+	for _, tt := range tests {
+		if got := isOrderStatus(tt.status); got != tt.want {
+			t.Errorf("isOrderStatus(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
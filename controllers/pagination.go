@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// pagination is the page requested through the ?page= and ?limit= query
+// parameters. Pages are numbered from 1.
+type pagination struct {
+	Page  int64
+	Limit int64
+}
+
+// parsePagination reads ?page= and ?limit=, falling back to page 1 and
+// defaultLimit. Limits above maxLimit are rejected.
+func parsePagination(r *http.Request, defaultLimit int64, maxLimit int64) (pagination, error) {
+	query := r.URL.Query()
+	p := pagination{Page: 1, Limit: defaultLimit}
+
+	if raw := query.Get("page"); raw != "" {
+		page, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || page < 1 {
+			return p, fmt.Errorf("page must be a positive number")
+		}
+		p.Page = page
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		p.Limit = limit
+	}
+
+	return p, nil
+}
+
+// skip is the number of documents before the requested page.
+func (p pagination) skip() int64 {
+	return (p.Page - 1) * p.Limit
+}
+
+// pageResponse wraps one page of results with the pagination metadata.
+func pageResponse(items interface{}, p pagination, total int64) map[string]interface{} {
+	totalPages := (total + p.Limit - 1) / p.Limit
+	return map[string]interface{}{
+		"items":      items,
+		"page":       p.Page,
+		"limit":      p.Limit,
+		"total":      total,
+		"totalPages": totalPages,
+		"hasMore":    p.Page < totalPages,
+	}
+}
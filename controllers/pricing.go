@@ -0,0 +1,261 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// priceBook holds the discounts that apply to each product and cart,
+// resolved from the active discounts. Stored prices are always the catalog
+// prices; the book is applied whenever a price is shown or charged.
+//
+// When several discounts match a product the highest percentage wins; they
+// never stack. Discounts limited to a variant only compete for that variant,
+// and quantity tiers for the cart line they apply to, against the product's
+// own best discount. A buy-X-get-Y discount replaces those for a cart line
+// when it saves more. The best cart-wide discount then comes off the total.
+type priceBook struct {
+	percentages map[primitive.ObjectID]float64
+	variants    map[primitive.ObjectID]float64               // By variant ID
+	tiers       map[primitive.ObjectID][]models.DiscountTier // Quantity tiers by product ID
+	bundles     map[primitive.ObjectID][]models.Discount     // Buy-X-get-Y discounts by product ID
+	carts       []models.Discount                            // Cart-wide discounts
+}
+
+// newPriceBook returns a book without any discounts.
+func newPriceBook() *priceBook {
+	return &priceBook{
+		percentages: map[primitive.ObjectID]float64{},
+		variants:    map[primitive.ObjectID]float64{},
+		tiers:       map[primitive.ObjectID][]models.DiscountTier{},
+		bundles:     map[primitive.ObjectID][]models.Discount{},
+	}
+}
+
+// activeDiscountFilter matches the discounts whose window contains now. A
+// missing startsAt or endsAt leaves that side of the window open.
+func activeDiscountFilter(now time.Time) bson.M {
+	return bson.M{"$and": bson.A{
+		bson.M{"$or": bson.A{bson.M{"startsAt": nil}, bson.M{"startsAt": bson.M{"$lte": now}}}},
+		bson.M{"$or": bson.A{bson.M{"endsAt": nil}, bson.M{"endsAt": bson.M{"$gt": now}}}},
+	}}
+}
+
+// loadPriceBook reads the discounts active now and collection membership.
+// Scheduled discounts need no job to start or end them; they are picked up
+// or dropped by the next read.
+func loadPriceBook(ctx context.Context, db *mongo.Database) (*priceBook, error) {
+	cursor, err := db.Collection("discounts").Find(ctx, activeDiscountFilter(time.Now()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load discounts: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var discounts []models.Discount
+	if err := cursor.All(ctx, &discounts); err != nil {
+		return nil, fmt.Errorf("failed to decode discounts: %w", err)
+	}
+
+	book := newPriceBook()
+	for _, discount := range discounts {
+		if err := book.add(ctx, db, discount); err != nil {
+			return nil, err
+		}
+	}
+	return book, nil
+}
+
+// add records discount in the book, resolving the products it targets.
+func (pb *priceBook) add(ctx context.Context, db *mongo.Database, discount models.Discount) error {
+	switch discount.TargetType {
+	case models.DiscountProduct:
+		if discount.VariantID != nil {
+			if discount.Percentage > pb.variants[*discount.VariantID] {
+				pb.variants[*discount.VariantID] = discount.Percentage
+			}
+			return nil
+		}
+		pb.offer(discount.TargetID, discount.Percentage)
+	case models.DiscountCollection:
+		var collection models.Collection
+		err := db.Collection("collections").FindOne(ctx, bson.M{"_id": discount.TargetID}).Decode(&collection)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to load collection %s: %w", discount.TargetID.Hex(), err)
+		}
+		for _, productID := range collection.ProductIds {
+			pb.offer(productID, discount.Percentage)
+		}
+	case models.DiscountTag:
+		opts := options.Find().SetProjection(bson.M{"_id": 1})
+		cursor, err := db.Collection("products").Find(ctx, bson.M{"tag": discount.Tag}, opts)
+		if err != nil {
+			return fmt.Errorf("failed to load products tagged %q: %w", discount.Tag, err)
+		}
+		var products []models.Product
+		if err := cursor.All(ctx, &products); err != nil {
+			return fmt.Errorf("failed to decode products tagged %q: %w", discount.Tag, err)
+		}
+		for _, product := range products {
+			pb.offer(product.ID, discount.Percentage)
+		}
+	case models.DiscountQuantityTier:
+		pb.tiers[discount.TargetID] = append(pb.tiers[discount.TargetID], discount.Tiers...)
+	case models.DiscountBuyXGetY:
+		pb.bundles[discount.TargetID] = append(pb.bundles[discount.TargetID], discount)
+	case models.DiscountCart:
+		pb.carts = append(pb.carts, discount)
+	}
+	return nil
+}
+
+// offer records percentage for productID if it beats the current best.
+func (pb *priceBook) offer(productID primitive.ObjectID, percentage float64) {
+	if percentage > pb.percentages[productID] {
+		pb.percentages[productID] = percentage
+	}
+}
+
+// discountFor returns the percentage off that applies to productID.
+func (pb *priceBook) discountFor(productID primitive.ObjectID) float64 {
+	if pb == nil {
+		return 0
+	}
+	return pb.percentages[productID]
+}
+
+// variantDiscountFor returns the percentage off that applies to a variant of
+// productID: the best of the product's and the variant's own discounts.
+func (pb *priceBook) variantDiscountFor(productID primitive.ObjectID, variantID primitive.ObjectID) float64 {
+	if pb == nil {
+		return 0
+	}
+	return math.Max(pb.percentages[productID], pb.variants[variantID])
+}
+
+// tierDiscountFor returns the percentage off that the quantity tiers of
+// productID give a cart line of quantity units.
+func (pb *priceBook) tierDiscountFor(productID primitive.ObjectID, quantity int) float64 {
+	if pb == nil {
+		return 0
+	}
+	best := 0.0
+	for _, tier := range pb.tiers[productID] {
+		if quantity >= tier.MinQuantity && tier.Percentage > best {
+			best = tier.Percentage
+		}
+	}
+	return best
+}
+
+// bundleDiscountFor returns the amount the best buy-X-get-Y discount of
+// productID takes off a cart line of quantity units at unitPrice.
+func (pb *priceBook) bundleDiscountFor(productID primitive.ObjectID, quantity int, unitPrice float64) float64 {
+	if pb == nil {
+		return 0
+	}
+	best := 0.0
+	for _, bundle := range pb.bundles[productID] {
+		groupSize := bundle.BuyQuantity + bundle.GetQuantity
+		if groupSize <= 0 {
+			continue
+		}
+		discounted := quantity / groupSize * bundle.GetQuantity
+		amount := roundPrice(float64(discounted) * unitPrice * bundle.Percentage / 100)
+		if amount > best {
+			best = amount
+		}
+	}
+	return best
+}
+
+// cartDiscountFor returns the amount the best cart-wide discount takes off a
+// cart totalling total, never more than the total.
+func (pb *priceBook) cartDiscountFor(total float64) float64 {
+	if pb == nil {
+		return 0
+	}
+	best := 0.0
+	for _, discount := range pb.carts {
+		if total < discount.MinSubtotal {
+			continue
+		}
+		amount := discount.AmountOff
+		if discount.Percentage > 0 {
+			amount = total * discount.Percentage / 100
+		}
+		if amount > best {
+			best = amount
+		}
+	}
+	return roundPrice(math.Min(best, total))
+}
+
+// pricedVariant is a variant with the price it sells for now.
+type pricedVariant struct {
+	models.ProductVariant
+	Price              float64  `json:"price"`
+	OriginalPrice      *float64 `json:"originalPrice,omitempty"` // Catalog price, when a discount lowers it
+	DiscountPercentage float64  `json:"discountPercentage,omitempty"`
+}
+
+// pricedProduct is a product with the price it sells for now. It encodes
+// like models.Product, with price, originalPrice and the variant prices
+// replaced.
+type pricedProduct struct {
+	models.Product
+	Price              float64         `json:"price"`
+	OriginalPrice      *float64        `json:"originalPrice,omitempty"` // Catalog price, when a discount lowers it
+	DiscountPercentage float64         `json:"discountPercentage,omitempty"`
+	Variants           []pricedVariant `json:"variants,omitempty"`
+}
+
+// price applies the discounts of the book to product. The stored price is
+// never changed; a product with variants sells from its cheapest variant
+// after discounts.
+func (pb *priceBook) price(product models.Product) pricedProduct {
+	priced := pricedProduct{
+		Product:            product,
+		DiscountPercentage: pb.discountFor(product.ID),
+	}
+	priced.Price = discountedPrice(product.Price, priced.DiscountPercentage)
+
+	for i, variant := range product.Variants {
+		v := pricedVariant{
+			ProductVariant:     variant,
+			DiscountPercentage: pb.variantDiscountFor(product.ID, variant.ID),
+		}
+		v.Price = discountedPrice(variant.Price, v.DiscountPercentage)
+		if v.Price < variant.Price {
+			v.OriginalPrice = &product.Variants[i].Price
+		}
+		if i == 0 || v.Price < priced.Price {
+			priced.Price = v.Price
+		}
+		priced.Variants = append(priced.Variants, v)
+	}
+
+	if priced.Price < product.Price {
+		priced.OriginalPrice = &priced.Product.Price
+	}
+	return priced
+}
+
+// discountedPrice takes percentage off price, rounded to cents.
+func discountedPrice(price float64, percentage float64) float64 {
+	return roundPrice(price - (price * percentage / 100))
+}
+
+func roundPrice(price float64) float64 {
+	return math.Round(price*100) / 100
+}
@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bookWith returns a price book holding discounts. Only the discount types
+// that need no database lookup can be added this way.
+func bookWith(t *testing.T, discounts ...models.Discount) *priceBook {
+	t.Helper()
+	book := newPriceBook()
+	for _, discount := range discounts {
+		if err := book.add(context.Background(), nil, discount); err != nil {
+			t.Fatalf("add(%+v): %v", discount, err)
+		}
+	}
+	return book
+}
+
+func TestPriceBestDiscountWins(t *testing.T) {
+	productID := primitive.NewObjectID()
+	cheap := primitive.NewObjectID()
+	dear := primitive.NewObjectID()
+	product := models.Product{
+		ID:    productID,
+		Price: 100,
+		Variants: []models.ProductVariant{
+			{ID: cheap, Price: 80},
+			{ID: dear, Price: 120},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		discounts     []models.Discount
+		wantProduct   float64 // Listed price: the cheapest variant after discounts
+		wantCheap     float64
+		wantDear      float64
+		wantDiscounts []float64 // Percentage of each variant
+	}{
+		{
+			name:          "no discounts",
+			wantProduct:   80,
+			wantCheap:     80,
+			wantDear:      120,
+			wantDiscounts: []float64{0, 0},
+		},
+		{
+			name: "highest product discount wins",
+			discounts: []models.Discount{
+				{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 10},
+				{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 25},
+				{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 20},
+			},
+			wantProduct:   60,
+			wantCheap:     60,
+			wantDear:      90,
+			wantDiscounts: []float64{25, 25},
+		},
+		{
+			name: "variant discount only applies to its variant",
+			discounts: []models.Discount{
+				{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 10},
+				{TargetType: models.DiscountProduct, TargetID: productID, VariantID: &dear, Percentage: 50},
+			},
+			wantProduct:   60,
+			wantCheap:     72,
+			wantDear:      60,
+			wantDiscounts: []float64{10, 50},
+		},
+		{
+			name: "smaller variant discount loses to the product discount",
+			discounts: []models.Discount{
+				{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 30},
+				{TargetType: models.DiscountProduct, TargetID: productID, VariantID: &cheap, Percentage: 5},
+			},
+			wantProduct:   56,
+			wantCheap:     56,
+			wantDear:      84,
+			wantDiscounts: []float64{30, 30},
+		},
+		{
+			name: "discounts of other products are ignored",
+			discounts: []models.Discount{
+				{TargetType: models.DiscountProduct, TargetID: primitive.NewObjectID(), Percentage: 50},
+			},
+			wantProduct:   80,
+			wantCheap:     80,
+			wantDear:      120,
+			wantDiscounts: []float64{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			priced := bookWith(t, tt.discounts...).price(product)
+
+			if priced.Price != tt.wantProduct {
+				t.Errorf("price = %v, want %v", priced.Price, tt.wantProduct)
+			}
+			if got := priced.Variants[0].Price; got != tt.wantCheap {
+				t.Errorf("cheap variant price = %v, want %v", got, tt.wantCheap)
+			}
+			if got := priced.Variants[1].Price; got != tt.wantDear {
+				t.Errorf("dear variant price = %v, want %v", got, tt.wantDear)
+			}
+			for i, want := range tt.wantDiscounts {
+				if got := priced.Variants[i].DiscountPercentage; got != want {
+					t.Errorf("variant %d discount = %v, want %v", i, got, want)
+				}
+				if discounted := priced.Variants[i].OriginalPrice != nil; discounted != (want > 0) {
+					t.Errorf("variant %d originalPrice set = %v, want %v", i, discounted, want > 0)
+				}
+			}
+			if product.Price != 100 || product.Variants[0].Price != 80 {
+				t.Errorf("price changed the catalog prices of the product")
+			}
+		})
+	}
+}
+
+func TestPriceWithoutVariants(t *testing.T) {
+	productID := primitive.NewObjectID()
+	product := models.Product{ID: productID, Price: 19.99}
+
+	priced := bookWith(t).price(product)
+	if priced.Price != 19.99 || priced.OriginalPrice != nil {
+		t.Errorf("undiscounted: price = %v, originalPrice = %v", priced.Price, priced.OriginalPrice)
+	}
+
+	book := bookWith(t, models.Discount{TargetType: models.DiscountProduct, TargetID: productID, Percentage: 15})
+	priced = book.price(product)
+	if priced.Price != 16.99 {
+		t.Errorf("price = %v, want 16.99", priced.Price)
+	}
+	if priced.OriginalPrice == nil || *priced.OriginalPrice != 19.99 {
+		t.Errorf("originalPrice = %v, want 19.99", priced.OriginalPrice)
+	}
+}
+
+func TestTierDiscountFor(t *testing.T) {
+	productID := primitive.NewObjectID()
+	book := bookWith(t, models.Discount{
+		TargetType: models.DiscountQuantityTier,
+		TargetID:   productID,
+		Tiers: []models.DiscountTier{
+			{MinQuantity: 3, Percentage: 5},
+			{MinQuantity: 5, Percentage: 10},
+			{MinQuantity: 10, Percentage: 20},
+		},
+	})
+
+	tests := []struct {
+		quantity int
+		want     float64
+	}{
+		{1, 0},
+		{2, 0},
+		{3, 5},
+		{4, 5},
+		{5, 10},
+		{9, 10},
+		{10, 20},
+		{100, 20},
+	}
+	for _, tt := range tests {
+		if got := book.tierDiscountFor(productID, tt.quantity); got != tt.want {
+			t.Errorf("tierDiscountFor(%d) = %v, want %v", tt.quantity, got, tt.want)
+		}
+	}
+
+	if got := book.tierDiscountFor(primitive.NewObjectID(), 10); got != 0 {
+		t.Errorf("tierDiscountFor(other product) = %v, want 0", got)
+	}
+	if got := (*priceBook)(nil).tierDiscountFor(productID, 10); got != 0 {
+		t.Errorf("nil book tierDiscountFor = %v, want 0", got)
+	}
+}
+
+func TestBundleDiscountFor(t *testing.T) {
+	productID := primitive.NewObjectID()
+	buyTwoGetOneFree := models.Discount{TargetType: models.DiscountBuyXGetY, TargetID: productID, BuyQuantity: 2, GetQuantity: 1, Percentage: 100}
+	buyOneGetOneHalf := models.Discount{TargetType: models.DiscountBuyXGetY, TargetID: productID, BuyQuantity: 1, GetQuantity: 1, Percentage: 50}
+
+	tests := []struct {
+		name      string
+		discounts []models.Discount
+		quantity  int
+		unitPrice float64
+		want      float64
+	}{
+		{"below one group", []models.Discount{buyTwoGetOneFree}, 2, 10, 0},
+		{"one group", []models.Discount{buyTwoGetOneFree}, 3, 10, 10},
+		{"partial second group", []models.Discount{buyTwoGetOneFree}, 5, 10, 10},
+		{"two groups", []models.Discount{buyTwoGetOneFree}, 6, 10, 20},
+		{"half off every second unit", []models.Discount{buyOneGetOneHalf}, 4, 9.99, 9.99},
+		{"best bundle wins", []models.Discount{buyTwoGetOneFree, buyOneGetOneHalf}, 6, 10, 20},
+		{"best bundle wins the other way", []models.Discount{buyTwoGetOneFree, buyOneGetOneHalf}, 4, 10, 10},
+		{"no bundle", nil, 6, 10, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := bookWith(t, tt.discounts...)
+			if got := book.bundleDiscountFor(productID, tt.quantity, tt.unitPrice); got != tt.want {
+				t.Errorf("bundleDiscountFor(%d, %v) = %v, want %v", tt.quantity, tt.unitPrice, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCartDiscountFor(t *testing.T) {
+	tenPercent := models.Discount{TargetType: models.DiscountCart, Percentage: 10}
+	fifteenOffOver100 := models.Discount{TargetType: models.DiscountCart, AmountOff: 15, MinSubtotal: 100}
+	fiftyOff := models.Discount{TargetType: models.DiscountCart, AmountOff: 50}
+
+	tests := []struct {
+		name      string
+		discounts []models.Discount
+		total     float64
+		want      float64
+	}{
+		{"no discount", nil, 80, 0},
+		{"percentage", []models.Discount{tenPercent}, 80, 8},
+		{"below minimum subtotal", []models.Discount{fifteenOffOver100}, 99.99, 0},
+		{"at minimum subtotal", []models.Discount{fifteenOffOver100}, 100, 15},
+		{"best discount wins", []models.Discount{tenPercent, fifteenOffOver100}, 120, 15},
+		{"percentage wins on large carts", []models.Discount{tenPercent, fifteenOffOver100}, 200, 20},
+		{"never more than the total", []models.Discount{fiftyOff}, 30, 30},
+		{"rounded to cents", []models.Discount{tenPercent}, 33.33, 3.33},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := bookWith(t, tt.discounts...)
+			if got := book.cartDiscountFor(tt.total); got != tt.want {
+				t.Errorf("cartDiscountFor(%v) = %v, want %v", tt.total, got, tt.want)
+			}
+		})
+	}
+}
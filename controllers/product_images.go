@@ -0,0 +1,294 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// loadProductForImages reads the product named by the {id} route variable.
+func loadProductForImages(ctx context.Context, w http.ResponseWriter, r *http.Request, db *mongo.Database) (models.Product, bool) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return models.Product{}, false
+	}
+
+	var product models.Product
+	if err := db.Collection("products").FindOne(ctx, bson.M{"_id": objID}).Decode(&product); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return models.Product{}, false
+	}
+	return product, true
+}
+
+// markPrimaryImage keeps exactly one image of images primary, the first one
+// marked so or else the first one, and returns its URL for the legacy image
+// field so older clients still show the right picture.
+func markPrimaryImage(images []models.ProductImage) string {
+	primary := -1
+	for i, image := range images {
+		if image.Primary && primary == -1 {
+			primary = i
+		}
+		images[i].Primary = false
+	}
+	if primary == -1 && len(images) > 0 {
+		primary = 0
+	}
+	if primary == -1 {
+		return ""
+	}
+	images[primary].Primary = true
+	return images[primary].URL
+}
+
+// prepareProductImages readies the gallery of a product about to be
+// created: every image gets a fresh ID, a product sent with only the legacy
+// image field gets a one-image gallery, and exactly one image is primary.
+func prepareProductImages(product *models.Product) error {
+	if len(product.Images) == 0 && strings.TrimSpace(product.Image) != "" {
+		product.Images = []models.ProductImage{{
+			URL:       strings.TrimSpace(product.Image),
+			AltText:   product.Title,
+			AltTextAr: product.TitleAr,
+			Primary:   true,
+		}}
+	}
+	for i := range product.Images {
+		image := &product.Images[i]
+		image.URL = strings.TrimSpace(image.URL)
+		if image.URL == "" {
+			return fmt.Errorf("every image needs a url")
+		}
+		if image.Width < 0 || image.Height < 0 {
+			return fmt.Errorf("width and height cannot be negative")
+		}
+		image.ID = primitive.NewObjectID()
+	}
+	product.Image = markPrimaryImage(product.Images)
+	return nil
+}
+
+// primaryImageUpdate is markPrimaryImage as an update pipeline. It runs
+// after $push and $pull so it fixes up the images actually stored, including
+// ones another admin added meanwhile.
+var primaryImageUpdate = mongo.Pipeline{
+	{{Key: "$set", Value: bson.M{"images": bson.M{"$ifNull": bson.A{"$images", bson.A{}}}}}},
+	{{Key: "$set", Value: bson.M{
+		"primaryIndex": bson.M{"$max": bson.A{0, bson.M{"$indexOfArray": bson.A{
+			bson.M{"$map": bson.M{"input": "$images", "as": "image", "in": bson.M{"$eq": bson.A{"$$image.primary", true}}}},
+			true,
+		}}}},
+	}}},
+	{{Key: "$set", Value: bson.M{
+		"images": bson.M{"$map": bson.M{
+			"input": bson.M{"$range": bson.A{0, bson.M{"$size": "$images"}}},
+			"as":    "i",
+			"in": bson.M{"$mergeObjects": bson.A{
+				bson.M{"$arrayElemAt": bson.A{"$images", "$$i"}},
+				bson.M{"primary": bson.M{"$eq": bson.A{"$$i", "$primaryIndex"}}},
+			}},
+		}},
+		"image": bson.M{"$ifNull": bson.A{bson.M{"$arrayElemAt": bson.A{"$images.url", "$primaryIndex"}}, ""}},
+	}}},
+	{{Key: "$unset", Value: "primaryIndex"}},
+}
+
+// changeProductImages applies update, a $push or $pull on images, to the
+// product matching filter, then fixes up the primary image and reads the
+// product back. It reports false when filter matched nothing.
+func changeProductImages(ctx context.Context, db *mongo.Database, filter bson.M, update bson.M) (models.Product, bool, error) {
+	products := db.Collection("products")
+	productID := filter["_id"]
+
+	update["$set"] = bson.M{"updatedAt": time.Now()}
+	res, err := products.UpdateOne(ctx, filter, bumpVersion(update))
+	if err != nil {
+		return models.Product{}, false, fmt.Errorf("failed to save images: %w", err)
+	}
+	if res.MatchedCount == 0 {
+		return models.Product{}, false, nil
+	}
+
+	if _, err := products.UpdateOne(ctx, bson.M{"_id": productID}, primaryImageUpdate); err != nil {
+		return models.Product{}, false, fmt.Errorf("failed to update primary image: %w", err)
+	}
+
+	var product models.Product
+	if err := products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		return models.Product{}, false, fmt.Errorf("failed to reload product: %w", err)
+	}
+	if product.Images == nil {
+		product.Images = []models.ProductImage{}
+	}
+	return product, true, nil
+}
+
+// AttachProductImage handles POST /products/{id}/images. The image must
+// already be uploaded through /api/upload.
+func AttachProductImage(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var image models.ProductImage
+	if err := json.NewDecoder(r.Body).Decode(&image); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	image.URL = strings.TrimSpace(image.URL)
+	if !strings.HasPrefix(image.URL, SirvBaseURL) {
+		http.Error(w, "url must be an image uploaded to "+SirvBaseURL, http.StatusBadRequest)
+		return
+	}
+	if image.Width < 0 || image.Height < 0 {
+		http.Error(w, "width and height cannot be negative", http.StatusBadRequest)
+		return
+	}
+	image.ID = primitive.NewObjectID()
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	product, ok := loadProductForImages(ctx, w, r, db)
+	if !ok {
+		return
+	}
+
+	// A new primary image goes first, where it takes over from the current one
+	push := bson.M{"$each": []models.ProductImage{image}}
+	if image.Primary {
+		push["$position"] = 0
+	}
+	product, found, err := changeProductImages(ctx, db, bson.M{"_id": product.ID}, bson.M{"$push": bson.M{"images": push}})
+	if err != nil {
+		fmt.Println("Error attaching product image:", err)
+		http.Error(w, "Failed to attach image", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	setVersionETag(w, product.Version)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(product.Images)
+}
+
+// ReorderProductImages handles PUT /products/{id}/images/order. The body
+// lists every image ID of the product in the new display order; the first
+// image becomes primary unless primaryId names another one. The order is
+// based on the gallery the client last read, so If-Match is required.
+func ReorderProductImages(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		ImageIDs  []string `json:"imageIds"`
+		PrimaryID string   `json:"primaryId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	product, ok := loadProductForImages(ctx, w, r, db)
+	if !ok {
+		return
+	}
+	if product.Version != ifMatch {
+		writeVersionConflict(w)
+		return
+	}
+
+	byID := make(map[string]models.ProductImage, len(product.Images))
+	for _, image := range product.Images {
+		byID[image.ID.Hex()] = image
+	}
+	if len(body.ImageIDs) != len(byID) {
+		http.Error(w, "imageIds must list every image of the product exactly once", http.StatusBadRequest)
+		return
+	}
+
+	ordered := make([]models.ProductImage, 0, len(body.ImageIDs))
+	for _, id := range body.ImageIDs {
+		image, found := byID[id]
+		if !found {
+			http.Error(w, "imageIds must list every image of the product exactly once", http.StatusBadRequest)
+			return
+		}
+		delete(byID, id)
+
+		image.Primary = id == body.PrimaryID
+		ordered = append(ordered, image)
+	}
+
+	image := markPrimaryImage(ordered)
+
+	// The whole gallery is written, so it must still be the one read
+	res, err := db.Collection("products").UpdateOne(ctx,
+		bson.M{"_id": product.ID, "version": product.Version},
+		bumpVersion(bson.M{"$set": bson.M{"images": ordered, "image": image, "updatedAt": time.Now()}}),
+	)
+	if err != nil {
+		fmt.Println("Error reordering product images:", err)
+		http.Error(w, "Failed to reorder images", http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		writeVersionConflict(w)
+		return
+	}
+
+	setVersionETag(w, product.Version+1)
+	json.NewEncoder(w).Encode(ordered)
+}
+
+// DetachProductImage handles DELETE /products/{id}/images/{imageId}. The
+// file stays on Sirv.
+func DetachProductImage(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	product, ok := loadProductForImages(ctx, w, r, db)
+	if !ok {
+		return
+	}
+
+	imageID, err := primitive.ObjectIDFromHex(mux.Vars(r)["imageId"])
+	if err != nil {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	product, found, err := changeProductImages(ctx, db,
+		bson.M{"_id": product.ID, "images.id": imageID},
+		bson.M{"$pull": bson.M{"images": bson.M{"id": imageID}}},
+	)
+	if err != nil {
+		fmt.Println("Error detaching product image:", err)
+		http.Error(w, "Failed to detach image", http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+
+	setVersionETag(w, product.Version)
+	json.NewEncoder(w).Encode(product.Images)
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"testing"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMarkPrimaryImage(t *testing.T) {
+	tests := []struct {
+		name        string
+		primary     []bool
+		wantPrimary int // Index of the primary image, -1 for none
+	}{
+		{"no images", nil, -1},
+		{"none marked", []bool{false, false, false}, 0},
+		{"one marked", []bool{false, true, false}, 1},
+		{"first marked wins", []bool{false, true, true}, 1},
+		{"single image", []bool{false}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			images := make([]models.ProductImage, len(tt.primary))
+			for i, primary := range tt.primary {
+				images[i] = models.ProductImage{URL: string(rune('a' + i)), Primary: primary}
+			}
+
+			url := markPrimaryImage(images)
+
+			wantURL := ""
+			if tt.wantPrimary >= 0 {
+				wantURL = images[tt.wantPrimary].URL
+			}
+			if url != wantURL {
+				t.Errorf("url = %q, want %q", url, wantURL)
+			}
+			for i, image := range images {
+				if image.Primary != (i == tt.wantPrimary) {
+					t.Errorf("image %d primary = %v, want %v", i, image.Primary, i == tt.wantPrimary)
+				}
+			}
+		})
+	}
+}
+
+func TestPrepareProductImages(t *testing.T) {
+	t.Run("legacy image seeds the gallery", func(t *testing.T) {
+		product := models.Product{Title: "Lamp", Image: "https://example.com/lamp.jpg"}
+		if err := prepareProductImages(&product); err != nil {
+			t.Fatal(err)
+		}
+		if len(product.Images) != 1 || product.Images[0].URL != product.Image || !product.Images[0].Primary {
+			t.Fatalf("images = %+v, want one primary image of %q", product.Images, product.Image)
+		}
+		if product.Images[0].ID.IsZero() {
+			t.Errorf("image has no ID")
+		}
+	})
+
+	t.Run("sent images get fresh IDs and one primary", func(t *testing.T) {
+		sent := primitive.NewObjectID()
+		product := models.Product{
+			Image: "https://example.com/ignored.jpg",
+			Images: []models.ProductImage{
+				{ID: sent, URL: "https://example.com/a.jpg"},
+				{URL: "https://example.com/b.jpg", Primary: true},
+				{URL: "https://example.com/c.jpg", Primary: true},
+			},
+		}
+		if err := prepareProductImages(&product); err != nil {
+			t.Fatal(err)
+		}
+		if product.Image != "https://example.com/b.jpg" {
+			t.Errorf("image = %q, want the primary image", product.Image)
+		}
+		for i, image := range product.Images {
+			if image.ID.IsZero() || image.ID == sent {
+				t.Errorf("image %d kept ID %v", i, image.ID)
+			}
+			if image.Primary != (i == 1) {
+				t.Errorf("image %d primary = %v", i, image.Primary)
+			}
+		}
+	})
+
+	t.Run("image without url", func(t *testing.T) {
+		product := models.Product{Images: []models.ProductImage{{URL: " "}}}
+		if err := prepareProductImages(&product); err == nil {
+			t.Errorf("prepareProductImages = nil, want an error")
+		}
+	})
+}
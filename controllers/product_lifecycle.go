@@ -0,0 +1,250 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Products are never removed right away. Archiving hides a product from the
+// storefront until it is restored; deleting hides it too, and the purge job
+// removes it for good once deletedProductRetention has passed. Only active
+// products are members of collections, so every status change moves the
+// product in or out of the collections named after its tags.
+
+// deletedProductRetention is how long a deleted product can be restored
+// before it is purged. PRODUCT_RETENTION_DAYS overrides it.
+func deletedProductRetention() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("PRODUCT_RETENTION_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+// productPurgeInterval is how often StartProductPurge runs the purge.
+const productPurgeInterval = 24 * time.Hour
+
+// isProductVisible reports whether the product is shown in the storefront.
+func isProductVisible(product models.Product) bool {
+	return product.Status == models.ProductActive
+}
+
+// wantsAdminView reports whether a product read asked for the admin view
+// with ?isAdmin=true and the caller is an admin. It needs the route to be
+// wrapped by utils.OptionalAuthMiddleware.
+func wantsAdminView(r *http.Request) bool {
+	return r.URL.Query().Get("isAdmin") == "true" && utils.RoleFromContext(r.Context()) == models.RoleAdmin
+}
+
+// removeFromCollections takes productID out of every collection holding it.
+func removeFromCollections(ctx context.Context, db *mongo.Database, productID primitive.ObjectID) error {
+	_, err := db.Collection("collections").UpdateMany(ctx,
+		bson.M{"productIds": productID},
+		bumpVersion(bson.M{"$pull": bson.M{"productIds": productID}}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to remove product from collections: %w", err)
+	}
+	return nil
+}
+
+// changeProductStatus moves the product with the ID in the route to status
+// when its current status is one of from, and writes the updated product.
+func changeProductStatus(w http.ResponseWriter, r *http.Request, db *mongo.Database, status string, from ...string) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	collection := db.Collection("products")
+
+	var product models.Product
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&product); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	allowed := false
+	for _, current := range from {
+		allowed = allowed || product.Status == current
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("Product is %s and cannot be made %s", product.Status, status), http.StatusConflict)
+		return
+	}
+
+	now := time.Now()
+	set := bson.M{"status": status, "updatedAt": now}
+	unset := bson.M{}
+	switch status {
+	case models.ProductArchived:
+		set["archivedAt"] = now
+		unset["deletedAt"] = ""
+	case models.ProductDeleted:
+		set["deletedAt"] = now
+		unset["archivedAt"] = ""
+	default:
+		unset["archivedAt"] = ""
+		unset["deletedAt"] = ""
+	}
+
+	// The status filter keeps two concurrent transitions from both applying
+	res, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objID, "status": product.Status},
+		bumpVersion(bson.M{"$set": set, "$unset": unset}),
+	)
+	if err != nil {
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		http.Error(w, "Product was changed by someone else, reload and try again", http.StatusConflict)
+		return
+	}
+
+	if status == models.ProductActive {
+		err = addToTagCollections(ctx, db, objID, product.Tag)
+	} else {
+		err = removeFromCollections(ctx, db, objID)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to update collections of product %s: %v", objID.Hex(), err)
+	}
+	catalogSuggestions.refresh(db)
+
+	var updated models.Product
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&updated); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	setVersionETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// ArchiveProduct handles POST /products/{id}/archive
+func ArchiveProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	changeProductStatus(w, r, db, models.ProductArchived, models.ProductActive)
+}
+
+// DeleteProduct handles DELETE /products/{id}. The product is only marked
+// deleted; it can be restored until the purge job removes it.
+func DeleteProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	changeProductStatus(w, r, db, models.ProductDeleted, models.ProductActive, models.ProductArchived)
+}
+
+// RestoreProduct handles POST /products/{id}/restore
+func RestoreProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	changeProductStatus(w, r, db, models.ProductActive, models.ProductArchived, models.ProductDeleted)
+}
+
+// purgeReport is the result of a purge run.
+type purgeReport struct {
+	DryRun        bool      `json:"dryRun"`
+	DeletedBefore time.Time `json:"deletedBefore"`
+	ProductIDs    []string  `json:"productIds"`
+}
+
+// purgeDeletedProducts removes the products deleted more than the retention
+// period ago. Orders keep their own copy of the SKU and titles, so they are
+// not affected.
+func purgeDeletedProducts(ctx context.Context, db *mongo.Database, dryRun bool) (purgeReport, error) {
+	report := purgeReport{DryRun: dryRun, DeletedBefore: time.Now().Add(-deletedProductRetention()), ProductIDs: []string{}}
+	filter := bson.M{"status": models.ProductDeleted, "deletedAt": bson.M{"$lt": report.DeletedBefore}}
+
+	products := db.Collection("products")
+	cursor, err := products.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return report, fmt.Errorf("failed to load deleted products: %w", err)
+	}
+	var expired []models.Product
+	if err := cursor.All(ctx, &expired); err != nil {
+		return report, fmt.Errorf("failed to decode deleted products: %w", err)
+	}
+
+	for _, product := range expired {
+		if !dryRun {
+			// Deleting by status too leaves products restored meanwhile alone
+			res, err := products.DeleteOne(ctx, bson.M{"_id": product.ID, "status": models.ProductDeleted})
+			if err != nil {
+				return report, fmt.Errorf("failed to purge product %s: %w", product.ID.Hex(), err)
+			}
+			if res.DeletedCount == 0 {
+				continue
+			}
+			if err := removeFromCollections(ctx, db, product.ID); err != nil {
+				log.Printf("Warning: %v", err)
+			}
+		}
+		report.ProductIDs = append(report.ProductIDs, product.ID.Hex())
+	}
+	return report, nil
+}
+
+// PurgeProducts handles POST /admin/products/purge?dryRun=true
+func PurgeProducts(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	dryRun := r.URL.Query().Get("dryRun") == "true"
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+
+	report, err := purgeDeletedProducts(ctx, db, dryRun)
+	if err != nil {
+		fmt.Println("Error purging products:", err)
+		http.Error(w, "Failed to purge products", http.StatusInternalServerError)
+		return
+	}
+
+	if !dryRun && len(report.ProductIDs) > 0 {
+		recordAuditEvent(ctx, db, models.AuditEvent{
+			Type:    models.AuditProductsPurged,
+			ActorID: utils.UserIDFromContext(r.Context()),
+			Details: map[string]interface{}{"productIds": report.ProductIDs},
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// StartProductPurge runs the purge in the background once a day.
+func StartProductPurge(db *mongo.Database) {
+	go func() {
+		ticker := time.NewTicker(productPurgeInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+			report, err := purgeDeletedProducts(ctx, db, false)
+			if err != nil {
+				log.Printf("Warning: product purge failed: %v", err)
+			} else if len(report.ProductIDs) > 0 {
+				recordAuditEvent(ctx, db, models.AuditEvent{
+					Type:    models.AuditProductsPurged,
+					Details: map[string]interface{}{"productIds": report.ProductIDs},
+				})
+				log.Printf("Purged %d deleted products", len(report.ProductIDs))
+			}
+			cancel()
+		}
+	}()
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// productSortFields maps the ?sort= values to product fields. A leading "-"
+// sorts descending.
+var productSortFields = map[string]string{
+	"price":     "price",
+	"createdAt": "createdAt",
+	"title":     "title",
+}
+
+// productQueryFromRequest builds the filter, sort order and page for a
+// product listing from its query parameters:
+//
+//	page, limit         offset pagination (default 24 per page, max 100)
+//	sort                price, createdAt or title, "-" prefix for descending
+//	minPrice, maxPrice  inclusive catalog price range, before discounts
+//	tag                 only products carrying this tag
+//	inStock=true        only products with stock left
+//	sku                 SKU prefix of the product or one of its variants
+func productQueryFromRequest(r *http.Request, isArabic bool) (bson.M, bson.D, pagination, error) {
+	query := r.URL.Query()
+
+	page, err := parsePagination(r, 24, 100)
+	if err != nil {
+		return nil, nil, page, err
+	}
+
+	filter := bson.M{}
+
+	price := bson.M{}
+	for param, operator := range map[string]string{"minPrice": "$gte", "maxPrice": "$lte"} {
+		if raw := query.Get(param); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || value < 0 {
+				return nil, nil, page, fmt.Errorf("%s must be a non-negative number", param)
+			}
+			price[operator] = value
+		}
+	}
+	if len(price) > 0 {
+		filter["price"] = price
+	}
+
+	if tag := query.Get("tag"); tag != "" {
+		filter["tag"] = tag
+	}
+
+	if query.Get("inStock") == "true" {
+		filter["stock"] = bson.M{"$gt": 0}
+	}
+
+	if sku := strings.TrimSpace(query.Get("sku")); sku != "" {
+		prefix := bson.M{"$regex": "^" + regexp.QuoteMeta(sku)}
+		filter["$or"] = bson.A{bson.M{"sku": prefix}, bson.M{"variants.sku": prefix}}
+	}
+
+	sortParam := query.Get("sort")
+	if sortParam == "" {
+		sortParam = "-createdAt"
+	}
+	direction := 1
+	if strings.HasPrefix(sortParam, "-") {
+		direction = -1
+		sortParam = sortParam[1:]
+	}
+	field, ok := productSortFields[sortParam]
+	if !ok {
+		return nil, nil, page, fmt.Errorf("sort must be one of price, createdAt or title")
+	}
+	if field == "title" && isArabic {
+		field = "titleAr"
+	}
+	// _id keeps the order stable between pages
+	sort := bson.D{{Key: field, Value: direction}, {Key: "_id", Value: direction}}
+
+	return filter, sort, page, nil
+}
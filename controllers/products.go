@@ -1,20 +1,24 @@
 package controllers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func validateProduct(product models.Product) error {
@@ -40,19 +44,28 @@ func CreateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	}
 
 	// Validate product
+	if err := prepareVariants(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := prepareProductImages(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err := validateProduct(product); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// MongoDB collections
 	productCollection := db.Collection("products")
-	collectionCollection := db.Collection("collections")
 
-	// Check if SKU is unique
-	var existingProduct models.Product
-	err := productCollection.FindOne(context.TODO(), bson.M{"sku": product.Sku}).Decode(&existingProduct)
-	if err == nil {
+	// Check if the SKU and variant SKUs are unique
+	taken, err := skuTaken(context.TODO(), db, productSkus(product), primitive.NilObjectID)
+	if err != nil {
+		http.Error(w, "Failed to check SKU", http.StatusInternalServerError)
+		return
+	}
+	if taken {
 		http.Error(w, "SKU already exists", http.StatusConflict)
 		return
 	}
@@ -61,6 +74,11 @@ func CreateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	timestamp := time.Now()
 	product.CreatedAt = timestamp
 	product.UpdatedAt = timestamp
+	product.SearchText = utils.ProductSearchText(product)
+	product.Version = 1
+	product.Status = models.ProductActive
+	product.ArchivedAt = nil
+	product.DeletedAt = nil
 
 	// Insert product into database
 	result, err := productCollection.InsertOne(context.TODO(), product)
@@ -73,55 +91,135 @@ func CreateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	insertedID := result.InsertedID.(primitive.ObjectID)
 
 	// Process multiple tags and update collections accordingly
-	if len(product.Tag) > 0 {
-		for _, tag := range product.Tag {
-			var collection models.Collection
-			err := collectionCollection.FindOne(context.TODO(), bson.M{"collectionName": tag}).Decode(&collection)
-
-			if err != nil { // Collection does not exist, create a new one
-				newCollection := models.Collection{
-					ID:             primitive.NewObjectID(),
-					CollectionName: tag,
-					ProductIds:     []primitive.ObjectID{insertedID},
-					ShowCollection: true,
-				}
-				_, err := collectionCollection.InsertOne(context.TODO(), newCollection)
-				if err != nil {
-					http.Error(w, "Failed to create collection", http.StatusInternalServerError)
-					return
-				}
-			} else { // Collection exists, update it
-				_, err := collectionCollection.UpdateOne(
-					context.TODO(),
-					bson.M{"_id": collection.ID},
-					bson.M{"$addToSet": bson.M{"productIds": insertedID}}, // Prevent duplicate IDs
-				)
-				if err != nil {
-					http.Error(w, "Failed to update collection", http.StatusInternalServerError)
-					return
-				}
-			}
-		}
+	if err := addToTagCollections(context.TODO(), db, insertedID, product.Tag); err != nil {
+		fmt.Println("Error updating tag collections:", err)
+		http.Error(w, "Failed to update collections", http.StatusInternalServerError)
+		return
 	}
 
+	catalogSuggestions.refresh(db)
+
 	// Send response
+	setVersionETag(w, product.Version)
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(product)
 }
 
+// addToTagCollections adds productID to the collection named after each of
+// tags, creating the collections that do not exist yet.
+func addToTagCollections(ctx context.Context, db *mongo.Database, productID primitive.ObjectID, tags []string) error {
+	collectionCollection := db.Collection("collections")
+
+	for _, tag := range tags {
+		var collection models.Collection
+		err := collectionCollection.FindOne(ctx, bson.M{"collectionName": tag}).Decode(&collection)
+
+		if err != nil { // Collection does not exist, create a new one
+			newCollection := models.Collection{
+				ID:             primitive.NewObjectID(),
+				CollectionName: tag,
+				ProductIds:     []primitive.ObjectID{productID},
+				ShowCollection: true,
+				Version:        1,
+			}
+			if _, err := collectionCollection.InsertOne(ctx, newCollection); err != nil {
+				return fmt.Errorf("failed to create collection %q: %w", tag, err)
+			}
+		} else { // Collection exists, update it
+			_, err := collectionCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": collection.ID},
+				bumpVersion(bson.M{"$addToSet": bson.M{"productIds": productID}}), // Prevent duplicate IDs
+			)
+			if err != nil {
+				return fmt.Errorf("failed to update collection %q: %w", tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+// syncTagCollections moves productID between tag collections after its tags
+// changed from oldTags to newTags.
+func syncTagCollections(ctx context.Context, db *mongo.Database, productID primitive.ObjectID, oldTags []string, newTags []string) error {
+	kept := map[string]bool{}
+	for _, tag := range newTags {
+		kept[tag] = true
+	}
+
+	var removed []string
+	for _, tag := range oldTags {
+		if !kept[tag] {
+			removed = append(removed, tag)
+		}
+		delete(kept, tag)
+	}
+
+	var added []string
+	for _, tag := range newTags {
+		if kept[tag] {
+			added = append(added, tag)
+			delete(kept, tag)
+		}
+	}
+
+	if len(removed) > 0 {
+		_, err := db.Collection("collections").UpdateMany(ctx,
+			bson.M{"collectionName": bson.M{"$in": removed}},
+			bumpVersion(bson.M{"$pull": bson.M{"productIds": productID}}),
+		)
+		if err != nil {
+			return fmt.Errorf("failed to remove product from collections: %w", err)
+		}
+	}
+	return addToTagCollections(ctx, db, productID, added)
+}
+
 func GetProducts(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	isArabic := strings.Contains(r.URL.Path, "/ar")
-	isAdmin := r.URL.Query().Get("isAdmin") == "true"
+	isAdmin := wantsAdminView(r)
 
+	filter, sort, page, err := productQueryFromRequest(r, isArabic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Admins see every product unless they ask for one status
+	status := r.URL.Query().Get("status")
+	switch {
+	case !isAdmin:
+		filter["status"] = models.ProductActive
+	case status == models.ProductActive || status == models.ProductArchived || status == models.ProductDeleted:
+		filter["status"] = status
+	case status != "":
+		http.Error(w, "status must be active, archived or deleted", http.StatusBadRequest)
+		return
+	}
+
+	listProducts(w, db, filter, sort, page, isArabic, isAdmin)
+}
+
+// listProducts writes one page of the products matching filter, with the
+// pagination metadata.
+func listProducts(w http.ResponseWriter, db *mongo.Database, filter bson.M, sort bson.D, page pagination, isArabic bool, isAdmin bool) {
 	collection := db.Collection("products")
-	cursor, err := collection.Find(context.TODO(), bson.M{})
+
+	total, err := collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		http.Error(w, "Failed to count products", http.StatusInternalServerError)
+		return
+	}
+
+	opts := options.Find().SetSort(sort).SetSkip(page.skip()).SetLimit(page.Limit)
+	cursor, err := collection.Find(context.TODO(), filter, opts)
 	if err != nil {
 		http.Error(w, "Failed to retrieve products", http.StatusInternalServerError)
 		return
 	}
 	defer cursor.Close(context.TODO())
 
-	var products []models.Product
+	products := []models.Product{}
 	if err := cursor.All(context.TODO(), &products); err != nil {
 		http.Error(w, "Failed to parse products", http.StatusInternalServerError)
 		return
@@ -129,16 +227,23 @@ func GetProducts(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 
 	if isAdmin {
 		// Return full data for admin
-		json.NewEncoder(w).Encode(products)
+		json.NewEncoder(w).Encode(pageResponse(products, page, total))
+		return
+	}
+
+	book, err := loadPriceBook(context.TODO(), db)
+	if err != nil {
+		fmt.Println("Error loading discounts:", err)
+		http.Error(w, "Failed to retrieve products", http.StatusInternalServerError)
 		return
 	}
 
 	// Format for user-facing API
-	var response []map[string]interface{}
+	response := []map[string]interface{}{}
 	for _, product := range products {
-		response = append(response, formatProductResponse(product, isArabic, false))
+		response = append(response, formatProductResponse(product, book, isArabic, false))
 	}
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(pageResponse(response, page, total))
 }
 
 func GetProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
@@ -164,16 +269,31 @@ func GetProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	}
 
 	isArabic := strings.Contains(r.URL.Path, "/ar")
-	isAdmin := r.URL.Query().Get("isAdmin") == "true"
+	isAdmin := wantsAdminView(r)
+	if !isAdmin && !isProductVisible(product) {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
 
+	setVersionETag(w, product.Version)
 	if isAdmin {
 		json.NewEncoder(w).Encode(product)
 		return
 	}
 
-	json.NewEncoder(w).Encode(formatProductResponse(product, isArabic, false))
+	book, err := loadPriceBook(context.TODO(), db)
+	if err != nil {
+		fmt.Println("Error loading discounts:", err)
+		http.Error(w, "Failed to retrieve product", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(formatProductResponse(product, book, isArabic, false))
 }
 
+// UpdateProduct handles PUT /products/{id}. Empty fields are left alone, so
+// PUT cannot set stock to 0, and existing variants keep their stock; both
+// are changed through PATCH.
 func UpdateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	vars := mux.Vars(r)
 	id := vars["id"]
@@ -188,6 +308,11 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var product models.Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
 		http.Error(w, "Invalid request payload", http.StatusBadRequest)
@@ -203,6 +328,10 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
+	if existing.Version != ifMatch {
+		writeVersionConflict(w)
+		return
+	}
 
 	// Then apply updates manually:
 	update := bson.M{}
@@ -231,120 +360,326 @@ func UpdateProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	if product.Price != 0 {
 		update["price"] = product.Price
 	}
-	// Use -1 as "no change" sentinel for stock?
-	if product.Stock != 0 {
+	if len(product.Variants) == 0 && len(existing.Variants) > 0 && (product.Price != 0 || product.Stock != 0) {
+		http.Error(w, "Price and stock are managed per variant", http.StatusBadRequest)
+		return
+	}
+	if len(product.Variants) > 0 {
+		for i := range product.Variants {
+			if current, ok := findVariant(existing, product.Variants[i].ID.Hex()); ok {
+				product.Variants[i].Stock = current.Stock
+			}
+		}
+		if err := prepareVariants(&product); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		update["variants"] = product.Variants
+		update["price"] = product.Price
+		update["stock"] = product.Stock
+	} else if product.Stock != 0 {
 		update["stock"] = product.Stock
 	}
 
+	var changedSkus []string
+	if sku, ok := update["sku"].(string); ok && sku != existing.Sku {
+		changedSkus = append(changedSkus, sku)
+	}
+	for _, variant := range product.Variants {
+		changedSkus = append(changedSkus, variant.Sku)
+	}
+	taken, err := skuTaken(context.TODO(), db, changedSkus, objID)
+	if err != nil {
+		http.Error(w, "Failed to check SKU", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "SKU already exists", http.StatusConflict)
+		return
+	}
+
 	// Always update the timestamp
 	update["updatedAt"] = time.Now()
 
-	_, err = collection.UpdateOne(context.TODO(), bson.M{"_id": objID}, bson.M{"$set": update})
+	filter := bson.M{"_id": objID, "version": ifMatch}
+	if _, writesStock := update["stock"]; writesStock {
+		filter = guardStock(filter, existing)
+	}
+
+	res, err := collection.UpdateOne(context.TODO(), filter, bumpVersion(bson.M{"$set": update}))
 	if err != nil {
 		http.Error(w, "Failed to update product", http.StatusInternalServerError)
 		return
 	}
+	if res.MatchedCount == 0 {
+		writeVersionConflict(w)
+		return
+	}
+	if after, written := writtenStock(existing, bson.M{"$set": update}); written {
+		recordStockAdjustment(context.TODO(), db, existing, after, utils.UserIDFromContext(r.Context()))
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(product)
-}
+	if err := refreshProductSearchText(context.TODO(), db, objID); err != nil {
+		log.Printf("Warning: failed to refresh search text of product %s: %v", id, err)
+	}
 
-func DeleteProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	// Archived and deleted products stay out of collections until restored
+	if tags, changed := update["tag"].([]string); changed && isProductVisible(existing) {
+		if err := syncTagCollections(context.TODO(), db, objID, existing.Tag, tags); err != nil {
+			log.Printf("Warning: failed to sync collections of product %s: %v", id, err)
+		}
+	}
 
-	if id == "" {
-		http.Error(w, "ID parameter is required", http.StatusBadRequest)
+	catalogSuggestions.refresh(db)
+
+	var updated models.Product
+	if err := collection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&updated); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
 
-	objID, err := primitive.ObjectIDFromHex(id)
+	setVersionETag(w, updated.Version)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(updated)
+}
+
+// readOnlyProductFields cannot be changed through PATCH. Images are managed
+// through the /products/{id}/images endpoints.
+var readOnlyProductFields = []string{"id", "version", "createdAt", "updatedAt", "image", "images", "status", "archivedAt", "deletedAt"}
+
+// PatchProduct handles PATCH /products/{id} with a JSON merge patch
+// (RFC 7396). Unlike PUT, explicit zeros, empty strings and nulls are applied,
+// so stock can be set to 0 and descriptions or tags cleared.
+func PatchProduct(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	objID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
 	if err != nil {
 		http.Error(w, "Invalid ID format", http.StatusBadRequest)
 		return
 	}
 
-	productCollection := db.Collection("products")
-	collectionCollection := db.Collection("collections")
+	ifMatch, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
 
-	var product models.Product
-	err = productCollection.FindOne(context.TODO(), bson.M{"_id": objID}).Decode(&product)
+	contentType := r.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, utils.MergePatchContentType) && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(w, "Content-Type must be "+utils.MergePatchContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	patch, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
+		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		http.Error(w, "Merge patch must be a JSON object", http.StatusBadRequest)
+		return
+	}
+	for _, field := range readOnlyProductFields {
+		if _, ok := fields[field]; ok {
+			http.Error(w, fmt.Sprintf("%s cannot be patched", field), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	collection := db.Collection("products")
+
+	var existing models.Product
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&existing); err != nil {
 		http.Error(w, "Product not found", http.StatusNotFound)
 		return
 	}
+	if existing.Version != ifMatch {
+		writeVersionConflict(w)
+		return
+	}
 
-	res, err := productCollection.DeleteOne(context.TODO(), bson.M{"_id": objID})
-	if err != nil || res.DeletedCount == 0 {
-		http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+	current, err := json.Marshal(existing)
+	if err != nil {
+		http.Error(w, "Failed to patch product", http.StatusInternalServerError)
+		return
+	}
+	patched, err := utils.ApplyMergePatch(current, patch)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	for _, tag := range product.Tag {
-		filter := bson.M{"collectionName": tag}
-		update := bson.M{
-			"$pull": bson.M{
-				"productIds": objID,
-			},
+	var product models.Product
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&product); err != nil {
+		http.Error(w, "Invalid product: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	_, patchesPrice := fields["price"]
+	_, patchesStock := fields["stock"]
+	if len(product.Variants) > 0 && (patchesPrice || patchesStock) {
+		http.Error(w, "Price and stock are managed per variant", http.StatusBadRequest)
+		return
+	}
+	if product.Stock < 0 {
+		http.Error(w, "Stock cannot be negative", http.StatusBadRequest)
+		return
+	}
+	if product.Tag == nil {
+		product.Tag = []string{}
+	}
+	if err := prepareVariants(&product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateProduct(product); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	taken, err := skuTaken(ctx, db, productSkus(product), objID)
+	if err != nil {
+		http.Error(w, "Failed to check SKU", http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		http.Error(w, "SKU already exists", http.StatusConflict)
+		return
+	}
+
+	// Only the fields named in the patch are written. Checkouts change stock
+	// without bumping the version, so writing back the stock that was read
+	// would undo their reservations.
+	patchable := map[string]interface{}{
+		"sku":           product.Sku,
+		"title":         product.Title,
+		"titleAr":       product.TitleAr,
+		"description":   product.Description,
+		"descriptionAr": product.DescriptionAr,
+		"price":         product.Price,
+		"tag":           product.Tag,
+		"stock":         product.Stock,
+	}
+	set := bson.M{"searchText": utils.ProductSearchText(product), "updatedAt": time.Now()}
+	for field, value := range patchable {
+		if _, ok := fields[field]; ok {
+			set[field] = value
 		}
-		_, err := collectionCollection.UpdateMany(context.TODO(), filter, update)
-		if err != nil {
-			log.Printf("Warning: failed to update collection with tag '%s': %v", tag, err)
+	}
+	update := bson.M{"$set": set}
+	if _, ok := fields["variants"]; ok {
+		if len(product.Variants) > 0 {
+			set["variants"] = product.Variants
+			set["price"] = product.Price
+			set["stock"] = product.Stock
+		} else {
+			update["$unset"] = bson.M{"variants": ""}
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
+	filter := bson.M{"_id": objID, "version": ifMatch}
+	if _, writesStock := writtenStock(existing, update); writesStock {
+		filter = guardStock(filter, existing)
+	}
+
+	res, err := collection.UpdateOne(ctx, filter, bumpVersion(update))
+	if err != nil {
+		http.Error(w, "Failed to update product", http.StatusInternalServerError)
+		return
+	}
+	if res.MatchedCount == 0 {
+		writeVersionConflict(w)
+		return
+	}
+	if after, written := writtenStock(existing, update); written {
+		recordStockAdjustment(ctx, db, existing, after, utils.UserIDFromContext(r.Context()))
+	}
+
+	if isProductVisible(existing) {
+		if err := syncTagCollections(ctx, db, objID, existing.Tag, product.Tag); err != nil {
+			log.Printf("Warning: failed to sync collections of product %s: %v", objID.Hex(), err)
+		}
+	}
+	catalogSuggestions.refresh(db)
+
+	var updated models.Product
+	if err := collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&updated); err != nil {
+		http.Error(w, "Product not found", http.StatusNotFound)
+		return
+	}
+
+	setVersionETag(w, updated.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
 }
 
-// Helper function to format product response based on language
-func formatProductResponse(product models.Product, isArabic bool, isAdmin bool) map[string]interface{} {
+// Helper function to format product response based on language, with the
+// prices after the discounts in book
+func formatProductResponse(product models.Product, book *priceBook, isArabic bool, isAdmin bool) map[string]interface{} {
+	priced := book.price(product)
 	if isArabic {
 		return map[string]interface{}{
-			"id":            product.ID,
-			"sku":           product.Sku,
-			"title":         product.TitleAr,
-			"description":   product.DescriptionAr,
-			"price":         product.Price,
-			"image":         product.Image,
-			"createdAt":     product.CreatedAt.Format(time.RFC3339),
-			"updatedAt":     product.UpdatedAt.Format(time.RFC3339),
-			"stock":         product.Stock,
-			"tag":           product.Tag,
-			"originalPrice": product.OriginalPrice, // Include original price if available
+			"id":                 product.ID,
+			"sku":                product.Sku,
+			"title":              product.TitleAr,
+			"description":        product.DescriptionAr,
+			"price":              priced.Price,
+			"image":              product.Image,
+			"images":             product.Images,
+			"createdAt":          product.CreatedAt.Format(time.RFC3339),
+			"updatedAt":          product.UpdatedAt.Format(time.RFC3339),
+			"stock":              product.Stock,
+			"variants":           priced.Variants,
+			"tag":                product.Tag,
+			"originalPrice":      priced.OriginalPrice, // Set when a discount lowers the price
+			"discountPercentage": priced.DiscountPercentage,
 		}
 	}
 	if isAdmin {
 		return map[string]interface{}{
-			"id":            product.ID,
-			"sku":           product.Sku,
-			"title":         product.Title,
-			"titleAr":       product.TitleAr,
-			"description":   product.Description,
-			"descriptionAr": product.DescriptionAr,
-			"price":         product.Price,
-			"image":         product.Image,
-			"createdAt":     product.CreatedAt.Format(time.RFC3339),
-			"updatedAt":     product.UpdatedAt.Format(time.RFC3339),
-			"stock":         product.Stock,
-			"tag":           product.Tag,
-			"originalPrice": product.OriginalPrice, // Include original price if available
+			"id":                 product.ID,
+			"sku":                product.Sku,
+			"title":              product.Title,
+			"titleAr":            product.TitleAr,
+			"description":        product.Description,
+			"descriptionAr":      product.DescriptionAr,
+			"price":              priced.Price,
+			"image":              product.Image,
+			"images":             product.Images,
+			"createdAt":          product.CreatedAt.Format(time.RFC3339),
+			"updatedAt":          product.UpdatedAt.Format(time.RFC3339),
+			"stock":              product.Stock,
+			"variants":           priced.Variants,
+			"tag":                product.Tag,
+			"originalPrice":      priced.OriginalPrice, // Set when a discount lowers the price
+			"discountPercentage": priced.DiscountPercentage,
 		}
 	}
 	return map[string]interface{}{
-		"id":            product.ID,
-		"sku":           product.Sku,
-		"title":         product.Title,
-		"description":   product.Description,
-		"price":         product.Price,
-		"image":         product.Image,
-		"createdAt":     product.CreatedAt.Format(time.RFC3339),
-		"updatedAt":     product.UpdatedAt.Format(time.RFC3339),
-		"stock":         product.Stock,
-		"tag":           product.Tag,
-		"originalPrice": product.OriginalPrice, // Include original price if available
+		"id":                 product.ID,
+		"sku":                product.Sku,
+		"title":              product.Title,
+		"description":        product.Description,
+		"price":              priced.Price,
+		"image":              product.Image,
+		"images":             product.Images,
+		"createdAt":          product.CreatedAt.Format(time.RFC3339),
+		"updatedAt":          product.UpdatedAt.Format(time.RFC3339),
+		"stock":              product.Stock,
+		"variants":           priced.Variants,
+		"tag":                product.Tag,
+		"originalPrice":      priced.OriginalPrice, // Set when a discount lowers the price
+		"discountPercentage": priced.DiscountPercentage,
 	}
 }
 
+// GetProductsByIDs returns the products with their prices after discounts.
+// Archived and deleted products are returned too, with their status, so
+// carts, wishlists and past orders can show them as unavailable.
 func GetProductsByIDs(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	var request struct {
 		ProductIds []string `json:"productIds"`
@@ -384,5 +719,16 @@ func GetProductsByIDs(w http.ResponseWriter, r *http.Request, db *mongo.Database
 		return
 	}
 
-	json.NewEncoder(w).Encode(products)
+	book, err := loadPriceBook(context.TODO(), db)
+	if err != nil {
+		fmt.Println("Error loading discounts:", err)
+		http.Error(w, "Failed to retrieve products", http.StatusInternalServerError)
+		return
+	}
+
+	priced := []pricedProduct{}
+	for _, product := range products {
+		priced = append(priced, book.price(product))
+	}
+	json.NewEncoder(w).Encode(priced)
 }
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// refreshProductSearchText recomputes the normalized search text of a
+// product after its text fields changed.
+func refreshProductSearchText(ctx context.Context, db *mongo.Database, productID primitive.ObjectID) error {
+	products := db.Collection("products")
+
+	var product models.Product
+	if err := products.FindOne(ctx, bson.M{"_id": productID}).Decode(&product); err != nil {
+		return err
+	}
+
+	_, err := products.UpdateByID(ctx, productID, bson.M{"$set": bson.M{"searchText": utils.ProductSearchText(product)}})
+	return err
+}
+
+// SearchProducts handles GET /products/search?q= and /ar/products/search?q=.
+// Results are ranked by text relevance and accept the same filters and
+// pagination as GetProducts.
+func SearchProducts(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	isArabic := strings.Contains(r.URL.Path, "/ar")
+
+	q := utils.NormalizeArabic(strings.TrimSpace(r.URL.Query().Get("q")))
+	if q == "" {
+		http.Error(w, "q parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	filter, _, page, err := productQueryFromRequest(r, isArabic)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter["$text"] = bson.M{"$search": q}
+	filter["status"] = models.ProductActive
+
+	collection := db.Collection("products")
+
+	total, err := collection.CountDocuments(context.TODO(), filter)
+	if err != nil {
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+	opts := options.Find().
+		SetProjection(score).
+		SetSort(bson.D{{Key: "score", Value: bson.M{"$meta": "textScore"}}, {Key: "_id", Value: 1}}).
+		SetSkip(page.skip()).
+		SetLimit(page.Limit)
+	cursor, err := collection.Find(context.TODO(), filter, opts)
+	if err != nil {
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(context.TODO())
+
+	products := []models.Product{}
+	if err := cursor.All(context.TODO(), &products); err != nil {
+		http.Error(w, "Failed to parse products", http.StatusInternalServerError)
+		return
+	}
+
+	book, err := loadPriceBook(context.TODO(), db)
+	if err != nil {
+		http.Error(w, "Failed to search products", http.StatusInternalServerError)
+		return
+	}
+
+	response := []map[string]interface{}{}
+	for _, product := range products {
+		response = append(response, formatProductResponse(product, book, isArabic, false))
+	}
+	json.NewEncoder(w).Encode(pageResponse(response, page, total))
+}
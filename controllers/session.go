@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// refreshTokenTTL is how long a refresh token can be used before the user
+// has to log in again.
+const refreshTokenTTL = 30 * 24 * time.Hour
+
+// issueSession creates a new access token and a stored refresh token for the
+// user with userID. The user is read from the database so the token carries
+// the current role and token version.
+func issueSession(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) (string, string, error) {
+	var user models.User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		return "", "", fmt.Errorf("failed to load user: %w", err)
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, _, err := storeRefreshToken(ctx, db, user.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	return token, refreshToken, nil
+}
+
+// storeRefreshToken generates a refresh token for userID and saves its hash.
+func storeRefreshToken(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) (string, primitive.ObjectID, error) {
+	refreshToken, hash, err := utils.NewOpaqueToken()
+	if err != nil {
+		return "", primitive.NilObjectID, err
+	}
+
+	now := time.Now()
+	record := models.RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		TokenHash: hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	if _, err := db.Collection("refresh_tokens").InsertOne(ctx, record); err != nil {
+		return "", primitive.NilObjectID, fmt.Errorf("failed to store refresh token: %w", err)
+	}
+
+	return refreshToken, record.ID, nil
+}
+
+// revokeUserSessions ends every active session of a user: all access tokens
+// are invalidated by bumping the token version and all refresh tokens are revoked.
+func revokeUserSessions(ctx context.Context, db *mongo.Database, userID primitive.ObjectID) error {
+	_, err := db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"tokenVersion": 1}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to bump token version: %w", err)
+	}
+
+	_, err = db.Collection("refresh_tokens").UpdateMany(ctx,
+		bson.M{"userId": userID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke refresh tokens: %w", err)
+	}
+	return nil
+}
+
+// RefreshHandler handles POST /auth/refresh. The presented refresh token is
+// rotated: it is revoked and a new access/refresh pair is returned. Presenting
+// an already rotated token is treated as theft and ends all of the user's sessions.
+func RefreshHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	refreshTokens := db.Collection("refresh_tokens")
+
+	var stored models.RefreshToken
+	err := refreshTokens.FindOne(ctx, bson.M{"tokenHash": utils.HashToken(body.RefreshToken)}).Decode(&stored)
+	if err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if stored.RevokedAt != nil {
+		if err := revokeUserSessions(ctx, db, stored.UserID); err != nil {
+			fmt.Println("Error revoking sessions after refresh token reuse:", err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		http.Error(w, "Refresh token expired", http.StatusUnauthorized)
+		return
+	}
+
+	var user models.User
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": stored.UserID}).Decode(&user); err != nil {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	newRefreshToken, newID, err := storeRefreshToken(ctx, db, user.ID)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		fmt.Println("Error storing refresh token:", err)
+		return
+	}
+
+	// Only one concurrent refresh may win the rotation
+	res, err := refreshTokens.UpdateOne(ctx,
+		bson.M{"_id": stored.ID, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": time.Now(), "replacedBy": newID}},
+	)
+	if err != nil || res.ModifiedCount == 0 {
+		refreshTokens.DeleteOne(ctx, bson.M{"_id": newID})
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token":        token,
+		"refreshToken": newRefreshToken,
+		"userId":       user.ID.Hex(),
+	})
+}
+
+// LogoutHandler handles POST /auth/logout. It revokes the caller's access
+// token and the given refresh token, or every session when allDevices is set.
+func LogoutHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+		AllDevices   bool   `json:"allDevices"`
+	}
+	// The body is optional
+	json.NewDecoder(r.Body).Decode(&body)
+
+	claims := utils.ClaimsFromContext(r.Context())
+	if claims == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if body.AllDevices {
+		if err := revokeUserSessions(ctx, db, userID); err != nil {
+			http.Error(w, "Could not log out", http.StatusInternalServerError)
+			fmt.Println("Error revoking sessions:", err)
+			return
+		}
+	} else {
+		revoked := models.RevokedToken{
+			TokenID:   claims.Id,
+			UserID:    claims.Subject,
+			ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		}
+		if _, err := db.Collection("revoked_tokens").InsertOne(ctx, revoked); err != nil && !mongo.IsDuplicateKeyError(err) {
+			http.Error(w, "Could not log out", http.StatusInternalServerError)
+			fmt.Println("Error revoking access token:", err)
+			return
+		}
+
+		if body.RefreshToken != "" {
+			_, err := db.Collection("refresh_tokens").UpdateOne(ctx,
+				bson.M{"tokenHash": utils.HashToken(body.RefreshToken), "userId": userID, "revokedAt": nil},
+				bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+			)
+			if err != nil {
+				http.Error(w, "Could not log out", http.StatusInternalServerError)
+				fmt.Println("Error revoking refresh token:", err)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out"})
+}
+
+// ChangePasswordHandler handles POST /auth/change-password. Changing the
+// password ends every existing session; a fresh one is returned to the caller.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	var body struct {
+		CurrentPassword string `json:"currentPassword"`
+		NewPassword     string `json:"newPassword"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, "Invalid input", http.StatusBadRequest)
+		return
+	}
+
+	if valError := validatePassword(body.NewPassword); valError != "" {
+		http.Error(w, valError, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := primitive.ObjectIDFromHex(utils.UserIDFromContext(r.Context()))
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	users := db.Collection("users")
+
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.CurrentPassword)); err != nil {
+		http.Error(w, "Wrong Password", http.StatusUnauthorized)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Could not hash password", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := users.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{"password": string(hashedPassword)}}); err != nil {
+		http.Error(w, "Could not update password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := revokeUserSessions(ctx, db, userID); err != nil {
+		http.Error(w, "Could not end existing sessions", http.StatusInternalServerError)
+		fmt.Println("Error revoking sessions:", err)
+		return
+	}
+
+	token, refreshToken, err := issueSession(ctx, db, userID)
+	if err != nil {
+		http.Error(w, "Could not generate token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message":      "Password changed",
+		"token":        token,
+		"refreshToken": refreshToken,
+		"userId":       user.ID.Hex(),
+	})
+}
@@ -4,6 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	_ "image/gif" // Registered so uploads can be measured
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"log"
 	"net/http"
@@ -203,6 +207,15 @@ func UploadImageToSirv(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Measure the image for the gallery metadata. Formats the standard
+	// library cannot decode, such as WebP, are uploaded without dimensions.
+	var width, height int
+	if _, err := tempFile.Seek(0, io.SeekStart); err == nil {
+		if config, _, err := image.DecodeConfig(tempFile); err == nil {
+			width, height = config.Width, config.Height
+		}
+	}
+
 	// Get Sirv authentication token.
 	token, err := GetSirvToken()
 	if err != nil {
@@ -228,6 +241,6 @@ func UploadImageToSirv(w http.ResponseWriter, r *http.Request) {
 
 	// Respond with the image URL as JSON.
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"url": imageURL})
+	json.NewEncoder(w).Encode(map[string]interface{}{"url": imageURL, "width": width, "height": height})
 	fmt.Println("Image uploaded successfully and URL returned:", imageURL)
 }
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"github.com/gorilla/mux"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordStockMovement appends an entry to the stock ledger.
+func recordStockMovement(ctx context.Context, db *mongo.Database, movement models.StockMovement) {
+	movement.ID = primitive.NewObjectID()
+	movement.CreatedAt = time.Now()
+
+	if _, err := db.Collection("stock_movements").InsertOne(ctx, movement); err != nil {
+		fmt.Printf("Warning: failed to record stock movement for product %s: %v\n", movement.ProductID.Hex(), err)
+	}
+}
+
+// recordStockAdjustment records an admin's edit of a product's stock, per
+// variant for variants and on the product for its own stock.
+func recordStockAdjustment(ctx context.Context, db *mongo.Database, before models.Product, after models.Product, actorID string) {
+	recordVariantStockChanges(ctx, db, before.ID, before.Variants, after.Variants, actorID)
+
+	// The stock of a product with variants is their total, recorded above
+	beforeStock, afterStock := before.Stock, after.Stock
+	if len(before.Variants) > 0 {
+		beforeStock = 0
+	}
+	if len(after.Variants) > 0 {
+		afterStock = 0
+	}
+	if afterStock != beforeStock {
+		recordStockMovement(ctx, db, models.StockMovement{
+			ProductID: before.ID,
+			Delta:     afterStock - beforeStock,
+			Reason:    models.StockAdminAdjustment,
+			ActorID:   actorID,
+		})
+	}
+}
+
+// writtenStock returns before with the stock and variants that update sets
+// or unsets, and whether it touches either. The stock adjustment of an edit
+// is computed from these written values, so a checkout that runs between the
+// write and a re-read is not logged as the admin's change.
+func writtenStock(before models.Product, update bson.M) (models.Product, bool) {
+	after, written := before, false
+	if set, ok := update["$set"].(bson.M); ok {
+		if stock, ok := set["stock"].(int32); ok {
+			after.Stock, written = stock, true
+		}
+		if variants, ok := set["variants"].([]models.ProductVariant); ok {
+			after.Variants, written = variants, true
+		}
+	}
+	if unset, ok := update["$unset"].(bson.M); ok {
+		if _, ok := unset["variants"]; ok {
+			after.Variants, written = nil, true
+		}
+	}
+	return after, written
+}
+
+// stockFilter matches the product of an order line, and only while at least
+// required units of it are in stock. Lines of a variant are checked against
+// the variant's stock.
+func stockFilter(productID primitive.ObjectID, item models.OrderItem, required int) bson.M {
+	if item.VariantID == "" {
+		filter := bson.M{"_id": productID}
+		if required > 0 {
+			filter["stock"] = bson.M{"$gte": required}
+		}
+		return filter
+	}
+
+	// An invalid variant ID matches nothing, which reads as out of stock
+	variantID, _ := primitive.ObjectIDFromHex(item.VariantID)
+	match := bson.M{"id": variantID}
+	if required > 0 {
+		match["stock"] = bson.M{"$gte": required}
+	}
+	return bson.M{"_id": productID, "variants": bson.M{"$elemMatch": match}}
+}
+
+// stockChange changes the stock of an order line's product by delta. Lines of
+// a variant change the variant and the product total, which stays their sum.
+func stockChange(item models.OrderItem, delta int) bson.M {
+	if item.VariantID == "" {
+		return bson.M{"$inc": bson.M{"stock": delta}}
+	}
+	return bson.M{"$inc": bson.M{"variants.$.stock": delta, "stock": delta}}
+}
+
+// guardStock adds the stock read in product to an update filter. Checkouts
+// change stock with $inc and leave the version alone, so an edit that writes
+// stock must also check it, or it would undo reservations made since the read.
+func guardStock(filter bson.M, product models.Product) bson.M {
+	filter["stock"] = product.Stock
+	for i, variant := range product.Variants {
+		filter[fmt.Sprintf("variants.%d.stock", i)] = variant.Stock
+	}
+	return filter
+}
+
+// reserveStock takes the ordered quantities out of stock. Each decrement is
+// conditional on enough stock being left, so concurrent orders can never
+// oversell. If any line fails the lines already taken are put back.
+func reserveStock(ctx context.Context, db *mongo.Database, items []models.OrderItem, orderID string) error {
+	products := db.Collection("products")
+
+	var reserved []models.OrderItem
+	for _, item := range items {
+		productID, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			return fmt.Errorf("invalid product id %q: %w", item.ProductID, err)
+		}
+
+		res, err := products.UpdateOne(ctx,
+			stockFilter(productID, item, item.Quantity),
+			stockChange(item, -item.Quantity),
+		)
+		if err == nil && res.MatchedCount == 0 {
+			err = &statusError{http.StatusConflict, fmt.Sprintf("%s is out of stock", item.Title)}
+		}
+		if err != nil {
+			restoreStock(ctx, db, reserved, orderID, models.StockReservationRollback)
+			return err
+		}
+
+		recordStockMovement(ctx, db, models.StockMovement{
+			ProductID: productID,
+			VariantID: item.VariantID,
+			Delta:     -int32(item.Quantity),
+			Reason:    models.StockOrderPlaced,
+			OrderID:   orderID,
+		})
+		reserved = append(reserved, item)
+	}
+	return nil
+}
+
+// restoreStock puts the quantities of items back into stock. Lines whose
+// product or variant no longer exists are skipped.
+func restoreStock(ctx context.Context, db *mongo.Database, items []models.OrderItem, orderID string, reason string) {
+	for _, item := range items {
+		productID, err := primitive.ObjectIDFromHex(item.ProductID)
+		if err != nil {
+			continue
+		}
+
+		res, err := db.Collection("products").UpdateOne(ctx,
+			stockFilter(productID, item, 0),
+			stockChange(item, item.Quantity),
+		)
+		if err != nil {
+			fmt.Printf("Warning: failed to restore stock of product %s for order %s: %v\n", item.ProductID, orderID, err)
+			continue
+		}
+		// The product or variant is gone, so there is no stock to put back
+		if res.MatchedCount == 0 {
+			fmt.Printf("Warning: product %s of order %s no longer exists, stock not restored\n", item.ProductID, orderID)
+			continue
+		}
+
+		recordStockMovement(ctx, db, models.StockMovement{
+			ProductID: productID,
+			VariantID: item.VariantID,
+			Delta:     int32(item.Quantity),
+			Reason:    reason,
+			OrderID:   orderID,
+		})
+	}
+}
+
+// orderHoldsStock reports whether order still holds stock that deleting it
+// should put back: checkout reserved it, no cancellation or return released
+// it, and it has not left the warehouse.
+func orderHoldsStock(order models.Order) bool {
+	if !order.StockReserved || order.StockReleased {
+		return false
+	}
+	return order.Status != models.OrderShipped && order.Status != models.OrderDelivered
+}
+
+// releaseOrderStock returns an order's stock exactly once, however many
+// times it is cancelled or whether it is cancelled and then deleted. Orders
+// whose checkout did not reserve stock have nothing to return.
+func releaseOrderStock(ctx context.Context, db *mongo.Database, order models.Order, reason string) error {
+	res, err := db.Collection("orders").UpdateOne(ctx,
+		bson.M{"_id": order.ID, "stockReserved": true, "stockReleased": bson.M{"$ne": true}},
+		bson.M{"$set": bson.M{"stockReleased": true}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return nil
+	}
+
+	restoreStock(ctx, db, order.Items, order.OrderID, reason)
+	return nil
+}
+
+// GetStockMovements handles GET /products/{id}/stock-movements
+func GetStockMovements(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	productID, err := primitive.ObjectIDFromHex(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid ID format", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := db.Collection("stock_movements").Find(ctx, bson.M{"productId": productID}, opts)
+	if err != nil {
+		http.Error(w, "Failed to retrieve stock movements", http.StatusInternalServerError)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	movements := []models.StockMovement{}
+	if err := cursor.All(ctx, &movements); err != nil {
+		http.Error(w, "Failed to parse stock movements", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(movements)
+}
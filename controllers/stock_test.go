@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"testing"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestOrderHoldsStock(t *testing.T) {
+	tests := []struct {
+		name  string
+		order models.Order
+		want  bool
+	}{
+		{"reserved and pending", models.Order{Status: models.OrderPending, StockReserved: true}, true},
+		{"reserved and packed", models.Order{Status: models.OrderPacked, StockReserved: true}, true},
+		{"shipped", models.Order{Status: models.OrderShipped, StockReserved: true}, false},
+		{"delivered", models.Order{Status: models.OrderDelivered, StockReserved: true}, false},
+		{"already released", models.Order{Status: models.OrderCancelled, StockReserved: true, StockReleased: true}, false},
+		{"never reserved", models.Order{Status: models.OrderPending}, false},
+	}
+	for _, tt := range tests {
+		if got := orderHoldsStock(tt.order); got != tt.want {
+			t.Errorf("%s: orderHoldsStock = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWrittenStock(t *testing.T) {
+	before := models.Product{Stock: 7, Variants: []models.ProductVariant{{Sku: "A", Stock: 3}, {Sku: "B", Stock: 4}}}
+	variants := []models.ProductVariant{{Sku: "A", Stock: 5}}
+
+	tests := []struct {
+		name         string
+		update       bson.M
+		wantWritten  bool
+		wantStock    int32
+		wantVariants int
+	}{
+		{"title only", bson.M{"$set": bson.M{"title": "Lamp"}}, false, 7, 2},
+		{"stock", bson.M{"$set": bson.M{"stock": int32(2)}}, true, 2, 2},
+		{"variants", bson.M{"$set": bson.M{"variants": variants, "stock": int32(5)}}, true, 5, 1},
+		{"variants removed", bson.M{"$set": bson.M{}, "$unset": bson.M{"variants": ""}}, true, 7, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			after, written := writtenStock(before, tt.update)
+			if written != tt.wantWritten {
+				t.Errorf("written = %v, want %v", written, tt.wantWritten)
+			}
+			if after.Stock != tt.wantStock || len(after.Variants) != tt.wantVariants {
+				t.Errorf("stock %d with %d variants, want %d with %d", after.Stock, len(after.Variants), tt.wantStock, tt.wantVariants)
+			}
+		})
+	}
+}
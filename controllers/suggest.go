@@ -0,0 +1,303 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// suggestEntry is one suggestable title in the in-process index.
+type suggestEntry struct {
+	Kind       string // "product" or "collection"
+	ID         string
+	Text       string // As displayed
+	Arabic     bool
+	normalized []rune
+	wordStarts []int // Rune offsets where a word begins in normalized
+}
+
+// suggestion is a match returned by the suggest endpoint.
+type suggestion struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+	ID   string `json:"id"`
+
+	score int
+}
+
+// suggestIndex holds product and collection titles in memory so the search
+// box can be answered without a database round trip. It is rebuilt in the
+// background whenever the catalog changes.
+type suggestIndex struct {
+	mu      sync.RWMutex
+	entries []suggestEntry
+	built   bool
+
+	refreshMu  sync.Mutex
+	dirty      bool
+	rebuilding bool
+}
+
+// catalogSuggestions is the suggest index shared by all requests.
+var catalogSuggestions = &suggestIndex{}
+
+// refresh schedules a rebuild. Changes arriving while a rebuild is running
+// are folded into one follow-up rebuild.
+func (s *suggestIndex) refresh(db *mongo.Database) {
+	s.refreshMu.Lock()
+	s.dirty = true
+	if s.rebuilding {
+		s.refreshMu.Unlock()
+		return
+	}
+	s.rebuilding = true
+	s.refreshMu.Unlock()
+
+	go func() {
+		for {
+			s.refreshMu.Lock()
+			if !s.dirty {
+				s.rebuilding = false
+				s.refreshMu.Unlock()
+				return
+			}
+			s.dirty = false
+			s.refreshMu.Unlock()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			if err := s.rebuild(ctx, db); err != nil {
+				log.Printf("Warning: failed to rebuild suggest index: %v", err)
+			}
+			cancel()
+		}
+	}()
+}
+
+// rebuild reloads every active product and visible collection title.
+func (s *suggestIndex) rebuild(ctx context.Context, db *mongo.Database) error {
+	var entries []suggestEntry
+
+	productOpts := options.Find().SetProjection(bson.M{"title": 1, "titleAr": 1})
+	cursor, err := db.Collection("products").Find(ctx, bson.M{"status": models.ProductActive}, productOpts)
+	if err != nil {
+		return fmt.Errorf("failed to load products: %w", err)
+	}
+	var products []models.Product
+	if err := cursor.All(ctx, &products); err != nil {
+		return fmt.Errorf("failed to decode products: %w", err)
+	}
+	for _, product := range products {
+		entries = appendSuggestEntry(entries, "product", product.ID.Hex(), product.Title, false)
+		entries = appendSuggestEntry(entries, "product", product.ID.Hex(), product.TitleAr, true)
+	}
+
+	cursor, err = db.Collection("collections").Find(ctx, bson.M{"showCollection": true})
+	if err != nil {
+		return fmt.Errorf("failed to load collections: %w", err)
+	}
+	var collections []models.Collection
+	if err := cursor.All(ctx, &collections); err != nil {
+		return fmt.Errorf("failed to decode collections: %w", err)
+	}
+	for _, collection := range collections {
+		entries = appendSuggestEntry(entries, "collection", collection.ID.Hex(), collection.CollectionName, false)
+		entries = appendSuggestEntry(entries, "collection", collection.ID.Hex(), collection.CollectionNameAr, true)
+	}
+
+	s.mu.Lock()
+	s.entries = entries
+	s.built = true
+	s.mu.Unlock()
+	return nil
+}
+
+func appendSuggestEntry(entries []suggestEntry, kind string, id string, text string, arabic bool) []suggestEntry {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return entries
+	}
+
+	normalized := []rune(utils.NormalizeArabic(text))
+	var wordStarts []int
+	for i, r := range normalized {
+		if r != ' ' && (i == 0 || normalized[i-1] == ' ') {
+			wordStarts = append(wordStarts, i)
+		}
+	}
+
+	return append(entries, suggestEntry{
+		Kind:       kind,
+		ID:         id,
+		Text:       text,
+		Arabic:     arabic,
+		normalized: normalized,
+		wordStarts: wordStarts,
+	})
+}
+
+// suggest returns up to limit entries in the requested language matching q.
+// Titles starting with q rank first, then titles with a word starting with q,
+// then titles with a word within a small edit distance of q.
+func (s *suggestIndex) suggest(q string, arabic bool, limit int) []suggestion {
+	query := []rune(utils.NormalizeArabic(strings.TrimSpace(q)))
+	if len(query) == 0 {
+		return []suggestion{}
+	}
+	maxEdits := allowedEdits(len(query))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	best := map[string]suggestion{}
+	for _, entry := range s.entries {
+		if entry.Arabic != arabic {
+			continue
+		}
+
+		score, ok := matchScore(entry, query, maxEdits)
+		if !ok {
+			continue
+		}
+
+		key := entry.Kind + ":" + entry.Text
+		if existing, seen := best[key]; seen && existing.score <= score {
+			continue
+		}
+		best[key] = suggestion{Text: entry.Text, Type: entry.Kind, ID: entry.ID, score: score}
+	}
+
+	results := make([]suggestion, 0, len(best))
+	for _, match := range best {
+		results = append(results, match)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score < results[j].score
+		}
+		if len(results[i].Text) != len(results[j].Text) {
+			return len(results[i].Text) < len(results[j].Text)
+		}
+		return results[i].Text < results[j].Text
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// allowedEdits is the typo tolerance for a query of n runes. Short queries
+// must match exactly, otherwise every title would match.
+func allowedEdits(n int) int {
+	switch {
+	case n < 3:
+		return 0
+	case n < 6:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// matchScore ranks how well query matches entry; lower is better.
+func matchScore(entry suggestEntry, query []rune, maxEdits int) (int, bool) {
+	best := -1
+	for _, start := range entry.wordStarts {
+		end := start + len(query)
+		if end <= len(entry.normalized) && string(entry.normalized[start:end]) == string(query) {
+			if start == 0 {
+				return 0, true
+			}
+			best = 1
+			continue
+		}
+		if best == 1 || maxEdits == 0 {
+			continue
+		}
+
+		// Compare against the word-aligned prefixes one rune shorter or
+		// longer too, so a missing or extra letter still matches
+		for length := len(query) - 1; length <= len(query)+1; length++ {
+			if length <= 0 || start+length > len(entry.normalized) {
+				continue
+			}
+			if d := editDistance(entry.normalized[start:start+length], query); d <= maxEdits {
+				if score := 1 + d; best == -1 || score < best {
+					best = score
+				}
+			}
+		}
+	}
+	return best, best >= 0
+}
+
+// editDistance is the optimal string alignment distance between a and b:
+// insertions, deletions, substitutions and swaps of adjacent letters each
+// count as one edit.
+func editDistance(a, b []rune) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// SuggestProducts handles GET /products/suggest?q=&limit= and its /ar variant.
+func SuggestProducts(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
+	isArabic := strings.Contains(r.URL.Path, "/ar") || r.URL.Query().Get("lang") == "ar"
+
+	limit := 8
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > 20 {
+			http.Error(w, "limit must be between 1 and 20", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	// The first request after startup builds the index itself
+	catalogSuggestions.mu.RLock()
+	built := catalogSuggestions.built
+	catalogSuggestions.mu.RUnlock()
+	if !built {
+		if err := catalogSuggestions.rebuild(r.Context(), db); err != nil {
+			log.Printf("Error building suggest index: %v", err)
+			http.Error(w, "Suggestions unavailable", http.StatusServiceUnavailable)
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(catalogSuggestions.suggest(r.URL.Query().Get("q"), isArabic, limit))
+}
@@ -76,22 +76,45 @@ func UpdateUser(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"first_name":   user.FirstName,
-			"last_name":    user.LastName,
-			"email":        user.Email,
-			"phone_number": user.PhoneNumber,
-			"location":     user.Location,
-		},
+	fields := bson.M{
+		"first_name":   user.FirstName,
+		"last_name":    user.LastName,
+		"email":        user.Email,
+		"phone_number": user.PhoneNumber,
+		"location":     user.Location,
 	}
 
+	// Role is only changed when explicitly provided
+	if user.Role != "" {
+		if !models.IsValidRole(user.Role) {
+			http.Error(w, "Invalid role", http.StatusBadRequest)
+			return
+		}
+		fields["role"] = user.Role
+	}
+
+	var existing models.User
+	if err := db.Collection("users").FindOne(context.TODO(), bson.M{"_id": id}).Decode(&existing); err != nil {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+
+	update := bson.M{"$set": fields}
+
 	_, err = db.Collection("users").UpdateOne(context.TODO(), bson.M{"_id": id}, update)
 	if err != nil {
 		http.Error(w, "Error updating user", http.StatusInternalServerError)
 		return
 	}
 
+	// Tokens issued under the old role must not outlive it
+	if role, ok := fields["role"].(string); ok && role != existing.Role {
+		if err := revokeUserSessions(context.TODO(), db, id); err != nil {
+			http.Error(w, "Error ending user sessions", http.StatusInternalServerError)
+			return
+		}
+	}
+
 	json.NewEncoder(w).Encode(map[string]string{"message": "User updated successfully"})
 }
 
@@ -103,6 +126,12 @@ func DeleteUser(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
+	// End every active session before the account goes away
+	if err := revokeUserSessions(context.TODO(), db, id); err != nil {
+		http.Error(w, "Error ending user sessions", http.StatusInternalServerError)
+		return
+	}
+
 	_, err = db.Collection("users").DeleteOne(context.TODO(), bson.M{"_id": id})
 	if err != nil {
 		http.Error(w, "Error deleting user", http.StatusInternalServerError)
@@ -0,0 +1,134 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// prepareVariants validates the variants of product and gives new ones an
+// ID. A product with variants is listed at its cheapest variant and its
+// stock is the total over all variants, so listings, sorting and the
+// inStock filter keep working on the product fields.
+func prepareVariants(product *models.Product) error {
+	if len(product.Variants) == 0 {
+		return nil
+	}
+
+	skus := map[string]bool{strings.TrimSpace(product.Sku): true}
+	var lowest float64
+	var total int32
+	for i := range product.Variants {
+		variant := &product.Variants[i]
+		variant.Sku = strings.TrimSpace(variant.Sku)
+		if variant.Sku == "" {
+			return fmt.Errorf("variant SKU is required")
+		}
+		if skus[variant.Sku] {
+			return fmt.Errorf("duplicate SKU %s", variant.Sku)
+		}
+		skus[variant.Sku] = true
+
+		if variant.Price <= 0 {
+			return fmt.Errorf("price is missing for variant %s", variant.Sku)
+		}
+		if variant.Stock < 0 {
+			return fmt.Errorf("stock cannot be negative for variant %s", variant.Sku)
+		}
+		if variant.ID.IsZero() {
+			variant.ID = primitive.NewObjectID()
+		}
+		if variant.Attributes == nil {
+			variant.Attributes = map[string]string{}
+		}
+
+		if i == 0 || variant.Price < lowest {
+			lowest = variant.Price
+		}
+		total += variant.Stock
+	}
+
+	product.Price = lowest
+	product.Stock = total
+	return nil
+}
+
+// productSkus returns the SKU of product and of each of its variants.
+func productSkus(product models.Product) []string {
+	var skus []string
+	if product.Sku != "" {
+		skus = append(skus, product.Sku)
+	}
+	for _, variant := range product.Variants {
+		skus = append(skus, variant.Sku)
+	}
+	return skus
+}
+
+// skuTaken reports whether any of skus is already used by a product or a
+// variant of a product other than exclude.
+func skuTaken(ctx context.Context, db *mongo.Database, skus []string, exclude primitive.ObjectID) (bool, error) {
+	if len(skus) == 0 {
+		return false, nil
+	}
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"sku": bson.M{"$in": skus}},
+		bson.M{"variants.sku": bson.M{"$in": skus}},
+	}}
+	if !exclude.IsZero() {
+		filter["_id"] = bson.M{"$ne": exclude}
+	}
+
+	count, err := db.Collection("products").CountDocuments(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// findVariant returns the variant of product with the given hex ID.
+func findVariant(product models.Product, variantID string) (models.ProductVariant, bool) {
+	for _, variant := range product.Variants {
+		if variant.ID.Hex() == variantID {
+			return variant, true
+		}
+	}
+	return models.ProductVariant{}, false
+}
+
+// recordVariantStockChanges writes ledger entries for the stock difference of
+// every variant between before and after, including added and removed ones.
+func recordVariantStockChanges(ctx context.Context, db *mongo.Database, productID primitive.ObjectID, before []models.ProductVariant, after []models.ProductVariant, actorID string) {
+	previous := map[primitive.ObjectID]int32{}
+	for _, variant := range before {
+		previous[variant.ID] = variant.Stock
+	}
+
+	record := func(variantID primitive.ObjectID, delta int32) {
+		if delta == 0 {
+			return
+		}
+		recordStockMovement(ctx, db, models.StockMovement{
+			ProductID: productID,
+			VariantID: variantID.Hex(),
+			Delta:     delta,
+			Reason:    models.StockAdminAdjustment,
+			ActorID:   actorID,
+		})
+	}
+
+	for _, variant := range after {
+		record(variant.ID, variant.Stock-previous[variant.ID])
+		delete(previous, variant.ID)
+	}
+	for variantID, stock := range previous {
+		record(variantID, -stock)
+	}
+}
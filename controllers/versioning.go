@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Products, collections and discounts carry a version that every edit
+// increments. Clients read it from the ETag of a GET and send it back in
+// If-Match, so an edit based on a stale copy fails instead of silently
+// overwriting someone else's change.
+
+// versionETag formats a document version as an ETag.
+func versionETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// setVersionETag sets the ETag header of a response.
+func setVersionETag(w http.ResponseWriter, version int64) {
+	w.Header().Set("ETag", versionETag(version))
+}
+
+// requireIfMatch returns the version the client's edit is based on, read
+// from If-Match. Without one it writes 428 Precondition Required.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		http.Error(w, "If-Match header with the ETag of the current version is required", http.StatusPreconditionRequired)
+		return 0, false
+	}
+
+	tag := strings.TrimPrefix(header, "W/")
+	version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+	if err != nil || !strings.HasPrefix(tag, `"`) || !strings.HasSuffix(tag, `"`) {
+		http.Error(w, fmt.Sprintf("If-Match must be a single ETag such as %s", versionETag(1)), http.StatusBadRequest)
+		return 0, false
+	}
+	return version, true
+}
+
+// writeVersionConflict writes the 412 returned when the stored version no
+// longer matches If-Match.
+func writeVersionConflict(w http.ResponseWriter) {
+	http.Error(w, "The resource was changed by someone else, reload and try again", http.StatusPreconditionFailed)
+}
+
+// bumpVersion adds the version increment to a Mongo update document.
+func bumpVersion(update bson.M) bson.M {
+	update["$inc"] = bson.M{"version": 1}
+	return update
+}
@@ -23,9 +23,13 @@ func AddToWishlist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 		return
 	}
 
-	userID := r.URL.Query().Get("userId")
-	if userID == "" || item.ProductID == "" {
-		http.Error(w, "Missing userId or productId", http.StatusBadRequest)
+	if item.ProductID == "" {
+		http.Error(w, "Missing productId", http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -60,9 +64,8 @@ func AddToWishlist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 
 // Get Wishlist
 func GetWishlist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
-	userID := r.URL.Query().Get("userId")
-	if userID == "" {
-		http.Error(w, "Missing userId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -87,10 +90,13 @@ func GetWishlist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 func RemoveFromWishlist(w http.ResponseWriter, r *http.Request, db *mongo.Database) {
 	vars := mux.Vars(r)
 	itemID := vars["itemId"]
-	userID := r.URL.Query().Get("userId")
+	if itemID == "" {
+		http.Error(w, "Missing itemId", http.StatusBadRequest)
+		return
+	}
 
-	if userID == "" || itemID == "" {
-		http.Error(w, "Missing userId or itemId", http.StatusBadRequest)
+	userID, ok := resolveUserID(w, r, r.URL.Query().Get("userId"))
+	if !ok {
 		return
 	}
 
@@ -1,13 +1,15 @@
 package main
 
 import (
-	"log"
+	"context"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 
 	"oldsouqs-backend/config"
 	"oldsouqs-backend/controllers" // Import your controllers package
+	"oldsouqs-backend/migrations"
 	"oldsouqs-backend/routes"
 
 	"github.com/joho/godotenv"
@@ -16,8 +18,9 @@ import (
 func enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // Allow all origins
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+		w.Header().Set("Access-Control-Expose-Headers", "ETag")
 
 		// Handle preflight OPTIONS request
 		if r.Method == "OPTIONS" {
@@ -73,6 +76,19 @@ func main() {
 		log.Fatal("Database connection failed:", err)
 	}
 
+	// Bring existing documents up to date with the current models
+	if err := migrations.Run(context.TODO(), db); err != nil {
+		log.Fatal("Database migration failed:", err)
+	}
+
+	// Create indexes used for lookups, uniqueness and expiry
+	if err := config.EnsureIndexes(context.TODO(), db); err != nil {
+		log.Println("Warning: index setup incomplete:", err)
+	}
+
+	// Remove deleted products once their retention period has passed
+	controllers.StartProductPurge(db)
+
 	// Pass database instance to routes
 	router := routes.SetupRoutes(db)
 
@@ -81,4 +97,4 @@ func main() {
 
 	log.Printf("Server starting on port %s...", port)
 	log.Fatal(http.ListenAndServe(":"+port, corsRouter))
-}
\ No newline at end of file
+}
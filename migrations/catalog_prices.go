@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// restoreCatalogPrices undoes the price changes of discounts applied before
+// prices were computed at read time: the catalog price saved in
+// originalPrice moves back into price.
+func restoreCatalogPrices(ctx context.Context, db *mongo.Database) error {
+	products := db.Collection("products")
+
+	_, err := products.UpdateMany(ctx,
+		bson.M{"originalPrice": bson.M{"$gt": 0}},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{"price": "$originalPrice"}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = products.UpdateMany(ctx,
+		bson.M{"originalPrice": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"originalPrice": ""}},
+	)
+	return err
+}
@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// retypeCollectionProductIDs converts the collection members that
+// UpdateCollection used to store as hex strings to ObjectIDs, so collection
+// discounts reach them. Strings that are not IDs are dropped.
+func retypeCollectionProductIDs(ctx context.Context, db *mongo.Database) error {
+	collections := db.Collection("collections")
+
+	cursor, err := collections.Find(ctx, bson.M{"productIds": bson.M{"$type": "string"}})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var collection struct {
+			ID         primitive.ObjectID `bson:"_id"`
+			ProductIds []interface{}      `bson:"productIds"`
+		}
+		if err := cursor.Decode(&collection); err != nil {
+			return err
+		}
+
+		productIDs := []primitive.ObjectID{}
+		seen := map[primitive.ObjectID]bool{}
+		for _, member := range collection.ProductIds {
+			var productID primitive.ObjectID
+			switch id := member.(type) {
+			case primitive.ObjectID:
+				productID = id
+			case string:
+				if productID, err = primitive.ObjectIDFromHex(id); err != nil {
+					continue
+				}
+			default:
+				continue
+			}
+			if !seen[productID] {
+				seen[productID] = true
+				productIDs = append(productIDs, productID)
+			}
+		}
+
+		_, err := collections.UpdateByID(ctx, collection.ID, bson.M{
+			"$set": bson.M{"productIds": productIDs},
+			"$inc": bson.M{"version": 1},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
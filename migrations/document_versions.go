@@ -0,0 +1,24 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillDocumentVersions gives products, collections and discounts created
+// before optimistic concurrency existed their first version, so they can be
+// edited with If-Match.
+func backfillDocumentVersions(ctx context.Context, db *mongo.Database) error {
+	for _, name := range []string{"products", "collections", "discounts"} {
+		_, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{"version": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"version": 1}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
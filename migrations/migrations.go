@@ -0,0 +1,56 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migration is a one-off data change that is applied once per database.
+type migration struct {
+	name string
+	run  func(ctx context.Context, db *mongo.Database) error
+}
+
+// all lists the migrations in the order they must be applied. Never rename
+// or reorder entries that have shipped.
+var all = []migration{
+	{"product-search-text", backfillProductSearchText},
+	{"product-images", migrateProductImages},
+	{"document-versions", backfillDocumentVersions},
+	{"product-status", backfillProductStatus},
+	{"catalog-prices", restoreCatalogPrices},
+	{"collection-product-ids", retypeCollectionProductIDs},
+	{"order-status", backfillOrderStatus},
+	{"order-numbers", dedupeOrderNumbers},
+}
+
+// Run applies every migration that is not yet recorded in the migrations
+// collection and records it once it succeeds.
+func Run(ctx context.Context, db *mongo.Database) error {
+	applied := db.Collection("migrations")
+
+	for _, m := range all {
+		count, err := applied.CountDocuments(ctx, bson.M{"_id": m.name})
+		if err != nil {
+			return fmt.Errorf("error checking migration %s: %w", m.name, err)
+		}
+		if count > 0 {
+			continue
+		}
+
+		log.Printf("Applying migration %s...", m.name)
+		if err := m.run(ctx, db); err != nil {
+			return fmt.Errorf("migration %s failed: %w", m.name, err)
+		}
+
+		if _, err := applied.InsertOne(ctx, bson.M{"_id": m.name, "appliedAt": time.Now()}); err != nil {
+			return fmt.Errorf("error recording migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// dedupeOrderNumbers renumbers the legacy orders that share an orderId,
+// since the old OS<unix-seconds> numbers collide for orders placed in the
+// same second, so the unique orderId index can be built. The oldest order
+// keeps the number and the others get a -2, -3, ... suffix. Orders without
+// a number get one derived from their ID.
+func dedupeOrderNumbers(ctx context.Context, db *mongo.Database) error {
+	orders := db.Collection("orders")
+
+	_, err := orders.UpdateMany(ctx,
+		bson.M{"orderId": bson.M{"$in": bson.A{nil, ""}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{"orderId": bson.M{"$concat": bson.A{"OS", bson.M{"$toString": "$_id"}}}}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := orders.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$sort", Value: bson.D{{Key: "creationDate", Value: 1}, {Key: "_id", Value: 1}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$orderId", "ids": bson.M{"$push": "$_id"}, "count": bson.M{"$sum": 1}}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var duplicate struct {
+			OrderID string               `bson:"_id"`
+			IDs     []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cursor.Decode(&duplicate); err != nil {
+			return err
+		}
+
+		suffix := 2
+		for _, id := range duplicate.IDs[1:] {
+			// Skip suffixes another order already has
+			var number string
+			for {
+				number = fmt.Sprintf("%s-%d", duplicate.OrderID, suffix)
+				suffix++
+				count, err := orders.CountDocuments(ctx, bson.M{"orderId": number})
+				if err != nil {
+					return err
+				}
+				if count == 0 {
+					break
+				}
+			}
+
+			if _, err := orders.UpdateByID(ctx, id, bson.M{"$set": bson.M{"orderId": number}}); err != nil {
+				return err
+			}
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillOrderStatus gives the orders placed before order statuses existed
+// a pending status and timeline, and records that they never reserved
+// stock, so cancelling or deleting them puts nothing back.
+func backfillOrderStatus(ctx context.Context, db *mongo.Database) error {
+	orders := db.Collection("orders")
+
+	// The timeline starts at the creation date, as a pipeline update
+	_, err := orders.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$in": bson.A{nil, ""}}},
+		mongo.Pipeline{{{Key: "$set", Value: bson.M{
+			"status": models.OrderPending,
+			"statusHistory": bson.M{"$ifNull": bson.A{"$statusHistory", bson.A{
+				bson.M{"status": models.OrderPending, "at": "$creationDate"},
+			}}},
+		}}}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = orders.UpdateMany(ctx,
+		bson.M{"stockReserved": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"stockReserved": false}},
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = orders.UpdateMany(ctx,
+		bson.M{"stockReleased": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"stockReleased": false}},
+	)
+	return err
+}
@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// migrateProductImages turns the single image of products created before
+// galleries existed into a one-image gallery.
+func migrateProductImages(ctx context.Context, db *mongo.Database) error {
+	products := db.Collection("products")
+
+	filter := bson.M{"image": bson.M{"$nin": bson.A{"", nil}}, "images.0": bson.M{"$exists": false}}
+	cursor, err := products.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return err
+		}
+
+		images := []models.ProductImage{{
+			ID:        primitive.NewObjectID(),
+			URL:       product.Image,
+			AltText:   product.Title,
+			AltTextAr: product.TitleAr,
+			Primary:   true,
+		}}
+		update := bson.M{"$set": bson.M{"images": images, "updatedAt": time.Now()}}
+		if _, err := products.UpdateByID(ctx, product.ID, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillProductSearchText fills the normalized search text of products
+// created before search existed.
+func backfillProductSearchText(ctx context.Context, db *mongo.Database) error {
+	products := db.Collection("products")
+
+	cursor, err := products.Find(ctx, bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var product models.Product
+		if err := cursor.Decode(&product); err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{"searchText": utils.ProductSearchText(product)}}
+		if _, err := products.UpdateByID(ctx, product.ID, update); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
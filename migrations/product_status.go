@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"context"
+
+	"oldsouqs-backend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// backfillProductStatus marks the products created before soft delete
+// existed as active, since the storefront only lists active products.
+func backfillProductStatus(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("products").UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": models.ProductActive}},
+	)
+	return err
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Audit event types
+const (
+	AuditLoginLocked   = "login_locked"
+	AuditLoginUnlocked = "login_unlocked"
+
+	AuditCollectionsReconciled = "collections_reconciled"
+	AuditProductsPurged        = "products_purged"
+)
+
+// AuditEvent is an entry in the admin-visible audit trail.
+type AuditEvent struct {
+	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id"`
+	Type      string                 `bson:"type" json:"type"`
+	Email     string                 `bson:"email,omitempty" json:"email,omitempty"`
+	IP        string                 `bson:"ip,omitempty" json:"ip,omitempty"`
+	ActorID   string                 `bson:"actorId,omitempty" json:"actorId,omitempty"` // Admin who triggered it, if any
+	Details   map[string]interface{} `bson:"details,omitempty" json:"details,omitempty"`
+	CreatedAt time.Time              `bson:"createdAt" json:"createdAt"`
+}
+
+// LoginAttempt tracks failed logins for one key, either an account email
+// ("email:<address>") or a client IP ("ip:<address>").
+type LoginAttempt struct {
+	Key           string     `bson:"_id" json:"key"`
+	Failures      int        `bson:"failures" json:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt" json:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+}
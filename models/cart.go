@@ -6,6 +6,7 @@ import (
 
 type CartItem struct {
 	ProductID string `json:"productId" bson:"productId"`
+	VariantID string `json:"variantId,omitempty" bson:"variantId,omitempty"`
 	Quantity  int    `json:"quantity" bson:"quantity"`
 }
 
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Coupon value types
+const (
+	CouponPercentage = "percentage"
+	CouponFixed      = "fixed"
+)
+
+// Coupon is a promo code a customer enters at checkout. It comes off the
+// order total after the automatic product and collection discounts.
+type Coupon struct {
+	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Code           string             `bson:"code" json:"code"`                     // Stored upper case, matched case-insensitively
+	Type           string             `bson:"type" json:"type"`                     // CouponPercentage or CouponFixed
+	Value          float64            `bson:"value" json:"value"`                   // Percentage 0–100, or an amount off
+	MinSubtotal    float64            `bson:"minSubtotal" json:"minSubtotal"`       // Order total the coupon needs, 0 for none
+	MaxUses        int                `bson:"maxUses" json:"maxUses"`               // Across all customers, 0 for unlimited
+	MaxUsesPerUser int                `bson:"maxUsesPerUser" json:"maxUsesPerUser"` // 0 for unlimited
+	Uses           int                `bson:"uses" json:"uses"`
+	ExpiresAt      *time.Time         `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	Version        int64              `bson:"version" json:"version"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt      time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// CouponRedemption counts how often one user redeemed one coupon. Its ID is
+// "<couponId>:<userId>" so the count can be upserted atomically.
+type CouponRedemption struct {
+	ID        string             `bson:"_id" json:"id"`
+	CouponID  primitive.ObjectID `bson:"couponId" json:"couponId"`
+	UserID    string             `bson:"userId" json:"userId"`
+	Uses      int                `bson:"uses" json:"uses"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
@@ -6,11 +6,59 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Discount target types. Product, collection and tag discounts lower the
+// price shown for each product; the others are applied to the cart at
+// checkout.
+const (
+	DiscountProduct      = "product"
+	DiscountCollection   = "collection"
+	DiscountTag          = "tag"           // Every product carrying Tag
+	DiscountCart         = "cart"          // Percentage or AmountOff off the whole cart
+	DiscountQuantityTier = "quantity_tier" // Tiers of a product by quantity ordered
+	DiscountBuyXGetY     = "bxgy"          // Percentage off GetQuantity of every BuyQuantity+GetQuantity units
+)
+
 type Discount struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	TargetType string             `bson:"targetType" json:"targetType"` // "product" or "collection"
-	TargetID   primitive.ObjectID `bson:"targetId" json:"targetId"`     // productId or collectionId
-	Percentage float64            `bson:"percentage" json:"percentage"` // 0–100
-	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	TargetType  string              `bson:"targetType" json:"targetType"`                   // One of the Discount* target types
+	TargetID    primitive.ObjectID  `bson:"targetId" json:"targetId"`                       // productId or collectionId
+	VariantID   *primitive.ObjectID `bson:"variantId,omitempty" json:"variantId,omitempty"` // Limits a product discount to one variant
+	Percentage  float64             `bson:"percentage" json:"percentage"`                   // 0–100
+	Tag         string              `bson:"tag,omitempty" json:"tag,omitempty"`
+	AmountOff   float64             `bson:"amountOff,omitempty" json:"amountOff,omitempty"`     // Cart discounts, instead of a percentage
+	MinSubtotal float64             `bson:"minSubtotal,omitempty" json:"minSubtotal,omitempty"` // Cart discounts
+	Tiers       []DiscountTier      `bson:"tiers,omitempty" json:"tiers,omitempty"`
+	BuyQuantity int                 `bson:"buyQuantity,omitempty" json:"buyQuantity,omitempty"`
+	GetQuantity int                 `bson:"getQuantity,omitempty" json:"getQuantity,omitempty"`
+	StartsAt    *time.Time          `bson:"startsAt,omitempty" json:"startsAt,omitempty"` // Active from, immediately when unset
+	EndsAt      *time.Time          `bson:"endsAt,omitempty" json:"endsAt,omitempty"`     // Active until, with no end when unset
+	State       string              `bson:"-" json:"state"`                               // Computed when read, see StateAt
+	Version     int64               `bson:"version" json:"version"`
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// DiscountTier is the percentage off a product when at least MinQuantity
+// units of it are ordered.
+type DiscountTier struct {
+	MinQuantity int     `bson:"minQuantity" json:"minQuantity"`
+	Percentage  float64 `bson:"percentage" json:"percentage"`
+}
+
+// Discount states, derived from the validity window
+const (
+	DiscountUpcoming = "upcoming"
+	DiscountActive   = "active"
+	DiscountExpired  = "expired"
+)
+
+// StateAt returns whether the discount is upcoming, active or expired at now.
+func (d Discount) StateAt(now time.Time) string {
+	if d.StartsAt != nil && now.Before(*d.StartsAt) {
+		return DiscountUpcoming
+	}
+	if d.EndsAt != nil && !now.Before(*d.EndsAt) {
+		return DiscountExpired
+	}
+	return DiscountActive
 }
@@ -6,15 +6,61 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Order statuses. See controllers.orderTransitions for the allowed moves.
+const (
+	OrderPending   = "pending"
+	OrderConfirmed = "confirmed"
+	OrderPacked    = "packed"
+	OrderShipped   = "shipped"
+	OrderDelivered = "delivered"
+	OrderCancelled = "cancelled"
+	OrderReturned  = "returned"
+)
+
+// OrderStatusChange is one entry of an order's status timeline.
+type OrderStatusChange struct {
+	Status  string    `bson:"status" json:"status"`
+	At      time.Time `bson:"at" json:"at"`
+	ActorID string    `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	Note    string    `bson:"note,omitempty" json:"note,omitempty"`
+}
+
+// OrderItem is an order line priced by the server at checkout. Product
+// details are copied so the order still reads correctly if the product changes.
+type OrderItem struct {
+	ProductID          string            `bson:"productId" json:"productId"`
+	VariantID          string            `bson:"variantId,omitempty" json:"variantId,omitempty"`
+	Quantity           int               `bson:"quantity" json:"quantity"`
+	Sku                string            `bson:"sku,omitempty" json:"sku,omitempty"`
+	Title              string            `bson:"title,omitempty" json:"title,omitempty"`
+	TitleAr            string            `bson:"titleAr,omitempty" json:"titleAr,omitempty"`
+	Attributes         map[string]string `bson:"attributes,omitempty" json:"attributes,omitempty"` // Of the ordered variant
+	UnitPrice          float64           `bson:"unitPrice" json:"unitPrice"`                       // Catalog price before discounts
+	DiscountPercentage float64           `bson:"discountPercentage" json:"discountPercentage"`     // 0 when not discounted
+	FinalUnitPrice     float64           `bson:"finalUnitPrice" json:"finalUnitPrice"`
+	PromotionDiscount  float64           `bson:"promotionDiscount,omitempty" json:"promotionDiscount,omitempty"` // Taken off the line by a buy-X-get-Y discount
+	LineTotal          float64           `bson:"lineTotal" json:"lineTotal"`
+}
+
 type Order struct {
-	ID             primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	OrderID        string             `bson:"orderId" json:"orderId"`
-	PhoneNumber	   string             `bson:"phoneNumber" json:"phoneNumber"`
-	UserID         string             `bson:"userId" json:"userId"`
-	Location       string             `bson:"userLocation" json:"userLocation"`
-	Items          []CartItem         `bson:"items" json:"items"`
-	Subtotal       float64            `bson:"subtotal" json:"subtotal"`
-	Total          float64            `bson:"total" json:"total"`
-	Discounted     bool               `bson:"discounted" json:"discounted"`
-	CreatedAt      time.Time          `bson:"creationDate" json:"creationDate"`
+	ID             primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	OrderID        string              `bson:"orderId" json:"orderId"`
+	PhoneNumber    string              `bson:"phoneNumber" json:"phoneNumber"`
+	UserID         string              `bson:"userId" json:"userId"`
+	Location       string              `bson:"userLocation" json:"userLocation"`
+	Items          []OrderItem         `bson:"items" json:"items"`
+	Subtotal       float64             `bson:"subtotal" json:"subtotal"`
+	CartDiscount   float64             `bson:"cartDiscount,omitempty" json:"cartDiscount,omitempty"` // Amount a cart-wide discount took off
+	CouponCode     string              `bson:"couponCode,omitempty" json:"couponCode,omitempty"`
+	CouponID       primitive.ObjectID  `bson:"couponId,omitempty" json:"couponId,omitempty"`
+	CouponDiscount float64             `bson:"couponDiscount,omitempty" json:"couponDiscount,omitempty"` // Amount the coupon took off
+	Total          float64             `bson:"total" json:"total"`
+	Discounted     bool                `bson:"discounted" json:"discounted"` // Set when a discount or coupon lowered the total
+	CreatedAt      time.Time           `bson:"creationDate" json:"creationDate"`
+	Status         string              `bson:"status" json:"status"`
+	StatusHistory  []OrderStatusChange `bson:"statusHistory" json:"statusHistory"`
+	CancelledAt    *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	StockReserved  bool                `bson:"stockReserved" json:"stockReserved"`                       // Set when checkout took the stock; older orders never did
+	StockReleased  bool                `bson:"stockReleased" json:"stockReleased"`                       // Set once the stock was put back
+	CouponReleased bool                `bson:"couponReleased,omitempty" json:"couponReleased,omitempty"` // Set once the coupon use was given back
 }
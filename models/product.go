@@ -6,6 +6,15 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Product statuses. Only active products are shown in the storefront;
+// archived and deleted ones stay in the database for admins and for the
+// orders that reference them.
+const (
+	ProductActive   = "active"
+	ProductArchived = "archived"
+	ProductDeleted  = "deleted"
+)
+
 type Product struct {
 	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
 	Sku           string             `bson:"sku" json:"sku"`
@@ -14,14 +23,41 @@ type Product struct {
 	Description   string             `bson:"description" json:"description"`
 	DescriptionAr string             `bson:"descriptionAr" json:"descriptionAr"`
 	Price         float64            `bson:"price" json:"price"`
-	OriginalPrice *float64           `bson:"originalPrice,omitempty" json:"originalPrice,omitempty"` // Added for discount reversion
-	Image         string             `bson:"image" json:"image"`
+	Image         string             `bson:"image" json:"image"`                       // URL of the primary image
+	Images        []ProductImage     `bson:"images,omitempty" json:"images,omitempty"` // Gallery in display order
 	Tag           []string           `bson:"tag" json:"tag"`
 	Stock         int32              `bson:"stock" json:"stock"`
+	Variants      []ProductVariant   `bson:"variants,omitempty" json:"variants,omitempty"` // When set, price and stock are derived from the variants
+	SearchText    string             `bson:"searchText,omitempty" json:"-"`                // Normalized copy of the text fields for search
+	Version       int64              `bson:"version" json:"version"`                       // Incremented by every admin edit
+	Status        string             `bson:"status" json:"status"`                         // ProductActive, ProductArchived or ProductDeleted
+	ArchivedAt    *time.Time         `bson:"archivedAt,omitempty" json:"archivedAt,omitempty"`
+	DeletedAt     *time.Time         `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"` // Purged for good once the retention period has passed
 	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt     time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
 
+// ProductImage is one image of a product's gallery, uploaded to Sirv.
+type ProductImage struct {
+	ID        primitive.ObjectID `bson:"id" json:"id"`
+	URL       string             `bson:"url" json:"url"`
+	AltText   string             `bson:"altText" json:"altText"`
+	AltTextAr string             `bson:"altTextAr" json:"altTextAr"`
+	Primary   bool               `bson:"primary" json:"primary"`
+	Width     int                `bson:"width,omitempty" json:"width,omitempty"`
+	Height    int                `bson:"height,omitempty" json:"height,omitempty"`
+}
+
+// ProductVariant is one purchasable version of a product, such as a size,
+// colour or condition, with its own SKU, price and stock.
+type ProductVariant struct {
+	ID         primitive.ObjectID `bson:"id" json:"id"`
+	Sku        string             `bson:"sku" json:"sku"`
+	Attributes map[string]string  `bson:"attributes" json:"attributes"` // e.g. {"size": "L", "condition": "used"}
+	Price      float64            `bson:"price" json:"price"`
+	Stock      int32              `bson:"stock" json:"stock"`
+}
+
 type Collection struct {
 	ID               primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
 	CollectionName   string               `bson:"collectionName" json:"collectionName"`
@@ -30,4 +66,5 @@ type Collection struct {
 	DescriptionAr    string               `bson:"descriptionAr" json:"descriptionAr"`
 	ProductIds       []primitive.ObjectID `bson:"productIds" json:"productIds"`
 	ShowCollection   bool                 `bson:"showCollection" json:"showCollection"`
+	Version          int64                `bson:"version" json:"version"`
 }
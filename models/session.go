@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RefreshToken is a server-side record of an issued refresh token. Only the
+// SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID  `bson:"userId" json:"userId"`
+	TokenHash  string              `bson:"tokenHash" json:"-"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time           `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt  *time.Time          `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	ReplacedBy *primitive.ObjectID `bson:"replacedBy,omitempty" json:"replacedBy,omitempty"` // Set when rotated
+}
+
+// RevokedToken blocks an access token (by its JWT ID) until it expires.
+type RevokedToken struct {
+	TokenID   string    `bson:"_id" json:"tokenId"`
+	UserID    string    `bson:"userId" json:"userId"`
+	ExpiresAt time.Time `bson:"expiresAt" json:"expiresAt"`
+}
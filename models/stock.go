@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons recorded on a StockMovement
+const (
+	StockOrderPlaced         = "order_placed"
+	StockOrderCancelled      = "order_cancelled"
+	StockOrderReturned       = "order_returned"
+	StockOrderDeleted        = "order_deleted"
+	StockReservationRollback = "reservation_rollback"
+	StockAdminAdjustment     = "admin_adjustment"
+)
+
+// StockMovement is a ledger entry for a single change to a product's, or
+// one of its variants', stock.
+type StockMovement struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductID primitive.ObjectID `bson:"productId" json:"productId"`
+	VariantID string             `bson:"variantId,omitempty" json:"variantId,omitempty"`
+	Delta     int32              `bson:"delta" json:"delta"` // Negative when stock was taken
+	Reason    string             `bson:"reason" json:"reason"`
+	OrderID   string             `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	ActorID   string             `bson:"actorId,omitempty" json:"actorId,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
@@ -2,14 +2,27 @@ package models
 
 import "go.mongodb.org/mongo-driver/bson/primitive"
 
+// User roles carried in the JWT claims
+const (
+	RoleCustomer = "customer"
+	RoleStaff    = "staff"
+	RoleAdmin    = "admin"
+)
+
 type User struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	FirstName   string             `bson:"first_name" json:"first_name"`
-	LastName    string             `bson:"last_name" json:"last_name"`
-	PhoneNumber string             `bson:"phonenumber" json:"phonenumber"`
-	Location    string             `bson:"location" json:"location"`
-	Email       string             `bson:"email" json:"email"`
-	Password    string             `bson:"password" json:"password"`
-  }
-  
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FirstName     string             `bson:"first_name" json:"first_name"`
+	LastName      string             `bson:"last_name" json:"last_name"`
+	PhoneNumber   string             `bson:"phonenumber" json:"phonenumber"`
+	Location      string             `bson:"location" json:"location"`
+	Email         string             `bson:"email" json:"email"`
+	Password      string             `bson:"password" json:"password"`
+	EmailVerified bool               `bson:"emailVerified" json:"emailVerified"`
+	Role          string             `bson:"role" json:"role"`      // customer, staff or admin
+	TokenVersion  int                `bson:"tokenVersion" json:"-"` // Bumped to revoke every issued token
+}
 
+// IsValidRole reports whether role is one of the known user roles.
+func IsValidRole(role string) bool {
+	return role == RoleCustomer || role == RoleStaff || role == RoleAdmin
+}
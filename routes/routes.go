@@ -2,8 +2,11 @@ package routes
 
 import (
 	"net/http"
+	"os"
 
 	"oldsouqs-backend/controllers"
+	"oldsouqs-backend/models"
+	"oldsouqs-backend/utils"
 
 	"github.com/gorilla/mux"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -12,91 +15,195 @@ import (
 func SetupRoutes(db *mongo.Database) *mux.Router {
 	router := mux.NewRouter()
 
+	// Outgoing email is logged until a real provider is configured
+	mailer := utils.NewLogMailer(os.Getenv("MAIL_LOG_FILE"))
+
+	// Route guards: authenticated wraps a handler that needs a logged-in
+	// caller, staffOnly and adminOnly additionally check the token role.
+	auth := utils.AuthMiddleware(db)
+	authenticated := func(h http.HandlerFunc) http.Handler {
+		return auth(h)
+	}
+	staffOnly := func(h http.HandlerFunc) http.Handler {
+		return auth(utils.RequireRole(models.RoleAdmin, models.RoleStaff)(h))
+	}
+	adminOnly := func(h http.HandlerFunc) http.Handler {
+		return auth(utils.RequireRole(models.RoleAdmin)(h))
+	}
+	// optionalAuth is for public routes that show admins more, such as
+	// archived products
+	optionalAuth := func(h http.HandlerFunc) http.Handler {
+		return utils.OptionalAuthMiddleware(db)(h)
+	}
+
 	// Auth routes
 	router.HandleFunc("/signup", func(w http.ResponseWriter, r *http.Request) {
-		controllers.SignupHandler(w, r, db)
+		controllers.SignupHandler(w, r, db, mailer)
 	}).Methods("POST")
 
 	router.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
 		controllers.LoginHandler(w, r, db)
 	}).Methods("POST")
 
+	router.HandleFunc("/auth/refresh", func(w http.ResponseWriter, r *http.Request) {
+		controllers.RefreshHandler(w, r, db)
+	}).Methods("POST")
+
+	router.Handle("/auth/logout", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.LogoutHandler(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/auth/change-password", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ChangePasswordHandler(w, r, db)
+	})).Methods("POST")
+
+	router.HandleFunc("/auth/forgot-password", func(w http.ResponseWriter, r *http.Request) {
+		controllers.ForgotPasswordHandler(w, r, db, mailer)
+	}).Methods("POST")
+
+	router.HandleFunc("/auth/reset-password", func(w http.ResponseWriter, r *http.Request) {
+		controllers.ResetPasswordHandler(w, r, db)
+	}).Methods("POST")
+
+	router.HandleFunc("/auth/verify-email", func(w http.ResponseWriter, r *http.Request) {
+		controllers.VerifyEmailHandler(w, r, db)
+	}).Methods("POST")
+
+	router.Handle("/auth/verify-email/resend", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ResendVerificationHandler(w, r, db, mailer)
+	})).Methods("POST")
+
 	// User routes
-	router.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/users", adminOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetUsers(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
-	router.HandleFunc("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/users/{userId}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetUserByID(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
-	router.HandleFunc("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/users/{userId}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.UpdateUser(w, r, db)
-	}).Methods("PUT")
+	})).Methods("PUT")
 
-	router.HandleFunc("/users/{userId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/users/{userId}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.DeleteUser(w, r, db)
-	}).Methods("DELETE")
+	})).Methods("DELETE")
+
+	// Admin security routes
+	router.Handle("/admin/audit-events", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetAuditEvents(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/admin/login-lockouts/unlock", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.UnlockLogin(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/admin/collections/reconcile", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ReconcileCollections(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/admin/products/purge", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.PurgeProducts(w, r, db)
+	})).Methods("POST")
 
 	// Product routes
-	router.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			controllers.GetProducts(w, r, db)
-		case http.MethodPost:
-			controllers.CreateProduct(w, r, db)
-		}
-	}).Methods("GET", "POST")
-
-	router.HandleFunc("/products/{id}", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			controllers.GetProduct(w, r, db)
-		case http.MethodPut:
-			controllers.UpdateProduct(w, r, db)
-		case http.MethodDelete:
-			controllers.DeleteProduct(w, r, db)
-		}
-	}).Methods("GET", "PUT", "DELETE")
+	router.Handle("/products", optionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetProducts(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/products", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.CreateProduct(w, r, db)
+	})).Methods("POST")
+
+	// Search routes are registered before /products/{id} so their names are not taken as IDs
+	router.HandleFunc("/products/search", func(w http.ResponseWriter, r *http.Request) {
+		controllers.SearchProducts(w, r, db)
+	}).Methods("GET")
+
+	router.HandleFunc("/ar/products/search", func(w http.ResponseWriter, r *http.Request) {
+		controllers.SearchProducts(w, r, db)
+	}).Methods("GET")
+
+	router.HandleFunc("/products/suggest", func(w http.ResponseWriter, r *http.Request) {
+		controllers.SuggestProducts(w, r, db)
+	}).Methods("GET")
+
+	router.HandleFunc("/ar/products/suggest", func(w http.ResponseWriter, r *http.Request) {
+		controllers.SuggestProducts(w, r, db)
+	}).Methods("GET")
+
+	// Bulk import and export, also before /products/{id}
+	router.Handle("/products/import", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ImportProductsHandler(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/products/export", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ExportProductsHandler(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/products/{id}", optionalAuth(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetProduct(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/products/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.UpdateProduct(w, r, db)
+	})).Methods("PUT")
+
+	router.Handle("/products/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.PatchProduct(w, r, db)
+	})).Methods("PATCH")
+
+	router.Handle("/products/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.DeleteProduct(w, r, db)
+	})).Methods("DELETE")
+
+	router.Handle("/products/{id}/archive", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ArchiveProduct(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/products/{id}/restore", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.RestoreProduct(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/products/{id}/stock-movements", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetStockMovements(w, r, db)
+	})).Methods("GET")
 
 	// Get products by IDs
 	router.HandleFunc("/products/ids", func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetProductsByIDs(w, r, db)
 	}).Methods("POST")
 
-	router.HandleFunc("/products", func(w http.ResponseWriter, r *http.Request) {
-		controllers.CreateProduct(w, r, db)
-	}).Methods("POST")
-
 	// Add Arabic routes
-	router.HandleFunc("/ar/products", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/ar/products", optionalAuth(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetProducts(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
-	router.HandleFunc("/ar/products/{id}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/ar/products/{id}", optionalAuth(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetProduct(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
 	// Collection routes
 	router.HandleFunc("/collections", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			controllers.GetCollections(w, r, db)
-		case http.MethodPost:
-			controllers.CreateCollection(w, r, db)
-		}
-	}).Methods("GET", "POST")
+		controllers.GetCollections(w, r, db)
+	}).Methods("GET")
+
+	router.Handle("/collections", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.CreateCollection(w, r, db)
+	})).Methods("POST")
 
 	router.HandleFunc("/collections/{id}", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodGet:
-			controllers.GetCollectionByID(w, r, db)
-		case http.MethodPut:
-			controllers.UpdateCollection(w, r, db)
-		case http.MethodDelete:
-			controllers.DeleteCollection(w, r, db)
-		}
-	}).Methods("GET", "PUT", "DELETE")
+		controllers.GetCollectionByID(w, r, db)
+	}).Methods("GET")
+
+	router.Handle("/collections/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.UpdateCollection(w, r, db)
+	})).Methods("PUT")
+
+	router.Handle("/collections/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.DeleteCollection(w, r, db)
+	})).Methods("DELETE")
 
 	// Get products by collection ID
 	router.HandleFunc("/collections/{id}/products", func(w http.ResponseWriter, r *http.Request) {
@@ -108,74 +215,131 @@ func SetupRoutes(db *mongo.Database) *mux.Router {
 	}).Methods("GET")
 
 	// Cart routes (RESTful)
-	router.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/cart", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.AddToCart(w, r, db)
-	}).Methods(http.MethodPost)
+	})).Methods(http.MethodPost)
 
-	router.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/cart", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetCart(w, r, db)
-	}).Methods(http.MethodGet)
+	})).Methods(http.MethodGet)
 
-	router.HandleFunc("/cart/{productId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/cart/{productId}", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.UpdateCartItem(w, r, db)
-	}).Methods(http.MethodPut)
+	})).Methods(http.MethodPut)
 
-	router.HandleFunc("/cart/{productId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/cart/{productId}", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.RemoveFromCart(w, r, db)
-	}).Methods(http.MethodDelete)
+	})).Methods(http.MethodDelete)
+
+	// Checkout
+	router.Handle("/checkout", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.Checkout(w, r, db)
+	})).Methods("POST")
 
 	// order
-	router.HandleFunc("/orders", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/me/orders", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetMyOrders(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/orders", staffOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetAllOrders(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
+
+	router.Handle("/orders/number/{orderNumber}", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetOrderByNumber(w, r, db)
+	})).Methods("GET")
 
-	router.HandleFunc("/orders/{orderId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/orders/{orderId}", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetOrder(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
-	router.HandleFunc("/orders/{userId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/orders/{userId}", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.CreateOrder(w, r, db)
-	}).Methods("POST")
+	})).Methods("POST")
 
-	router.HandleFunc("/orders/{orderId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/orders/{orderId}", staffOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.UpdateOrder(w, r, db)
-	}).Methods("PUT")
+	})).Methods("PUT")
+
+	router.Handle("/orders/{orderId}/status", staffOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.UpdateOrderStatus(w, r, db)
+	})).Methods("POST")
 
-	router.HandleFunc("/orders/{orderId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/orders/{orderId}/cancel", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.CancelOrder(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/orders/{orderId}", staffOnly(func(w http.ResponseWriter, r *http.Request) {
 		controllers.DeleteOrder(w, r, db)
-	}).Methods("DELETE")
+	})).Methods("DELETE")
 
 	// Wishlist
-	router.HandleFunc("/wishlist", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/wishlist", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.AddToWishlist(w, r, db)
-	}).Methods("POST")
+	})).Methods("POST")
 
-	router.HandleFunc("/wishlist", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/wishlist", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.GetWishlist(w, r, db)
-	}).Methods("GET")
+	})).Methods("GET")
 
-	router.HandleFunc("/wishlist/{itemId}", func(w http.ResponseWriter, r *http.Request) {
+	router.Handle("/wishlist/{itemId}", authenticated(func(w http.ResponseWriter, r *http.Request) {
 		controllers.RemoveFromWishlist(w, r, db)
-	}).Methods("DELETE")
+	})).Methods("DELETE")
+
+	// Product image gallery
+	router.Handle("/products/{id}/images", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.AttachProductImage(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/products/{id}/images/order", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ReorderProductImages(w, r, db)
+	})).Methods("PUT")
+
+	router.Handle("/products/{id}/images/{imageId}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.DetachProductImage(w, r, db)
+	})).Methods("DELETE")
 
 	// Sirv Handle
-	router.HandleFunc("/api/upload", controllers.UploadImageToSirv).Methods("POST")
+	router.Handle("/api/upload", adminOnly(controllers.UploadImageToSirv)).Methods("POST")
 
 	// Discount routes
 	controller := controllers.NewDiscountController(db)
 
-	router.HandleFunc("/discounts", controller.CreateDiscount).Methods("POST")
+	router.Handle("/discounts", adminOnly(controller.CreateDiscount)).Methods("POST")
 	router.HandleFunc("/discounts", controller.GetDiscounts).Methods("GET")
-	router.HandleFunc("/discounts/{id}", controller.UpdateDiscount).Methods("PUT")
-	router.HandleFunc("/discounts/{id}", controller.DeleteDiscount).Methods("DELETE")
+	router.Handle("/discounts/preview", adminOnly(controller.PreviewDiscount)).Methods("POST")
+	router.HandleFunc("/discounts/{id}", controller.GetDiscount).Methods("GET")
+	router.Handle("/discounts/{id}", adminOnly(controller.UpdateDiscount)).Methods("PUT")
+	router.Handle("/discounts/{id}", adminOnly(controller.DeleteDiscount)).Methods("DELETE")
+
+	// Coupon routes
+	router.Handle("/coupons/validate", authenticated(func(w http.ResponseWriter, r *http.Request) {
+		controllers.ValidateCoupon(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/coupons", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.CreateCoupon(w, r, db)
+	})).Methods("POST")
+
+	router.Handle("/coupons", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.GetCoupons(w, r, db)
+	})).Methods("GET")
+
+	router.Handle("/coupons/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.UpdateCoupon(w, r, db)
+	})).Methods("PUT")
+
+	router.Handle("/coupons/{id}", adminOnly(func(w http.ResponseWriter, r *http.Request) {
+		controllers.DeleteCoupon(w, r, db)
+	})).Methods("DELETE")
 
 	// Announcement routes
 	announcementController := controllers.NewAnnouncementController(db)
-	
-	router.HandleFunc("/announcements", announcementController.CreateAnnouncement).Methods("POST")
+
+	router.Handle("/announcements", adminOnly(announcementController.CreateAnnouncement)).Methods("POST")
 	router.HandleFunc("/announcements", announcementController.GetAnnouncements).Methods("GET")
-	router.HandleFunc("/announcements/{id}", announcementController.UpdateAnnouncement).Methods("PUT")
-	router.HandleFunc("/announcements/{id}", announcementController.DeleteAnnouncement).Methods("DELETE")
+	router.Handle("/announcements/{id}", adminOnly(announcementController.UpdateAnnouncement)).Methods("PUT")
+	router.Handle("/announcements/{id}", adminOnly(announcementController.DeleteAnnouncement)).Methods("DELETE")
 
 	return router
 }
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"strings"
+
+	"oldsouqs-backend/models"
+)
+
+// arabicReplacer folds the letter variants shoppers type interchangeably:
+// the alef forms, taa marbuta, alef maqsura and hamza carriers.
+var arabicReplacer = strings.NewReplacer(
+	"أ", "ا",
+	"إ", "ا",
+	"آ", "ا",
+	"ٱ", "ا",
+	"ة", "ه",
+	"ى", "ي",
+	"ؤ", "و",
+	"ئ", "ي",
+)
+
+// NormalizeArabic strips diacritics (tashkeel) and tatweel from s, folds
+// letter variants and lower-cases any Latin text, so that differently
+// spelled queries and product titles compare equal.
+func NormalizeArabic(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		switch {
+		case r >= '\u064B' && r <= '\u065F', r == '\u0670': // Harakat, tanween, shadda, sukun, superscript alef
+			continue
+		case r == '\u0640': // Tatweel
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(arabicReplacer.Replace(b.String()))
+}
+
+// ProductSearchText is the normalized text stored on a product so the text
+// index matches Arabic queries regardless of spelling variants.
+func ProductSearchText(product models.Product) string {
+	parts := []string{product.TitleAr, product.DescriptionAr}
+	parts = append(parts, product.Tag...)
+	return NormalizeArabic(strings.Join(parts, " "))
+}
@@ -2,27 +2,58 @@ package utils
 
 import (
 	"context"
+	"log"
+	"net/http"
+	"os"
 	"strings"
 	"time"
-	"net/http"
 
 	"github.com/dgrijalva/jwt-go"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 
 	"oldsouqs-backend/models"
-	"os"
-	"log"
 )
 
 // Secret key for signing the JWT token (Should be moved to an environment variable)
 var jwtKey = []byte(os.Getenv("JWT_SECRET_KEY"))
 
+// AccessTokenTTL is how long an access token is valid. Clients renew it
+// with their refresh token.
+const AccessTokenTTL = 15 * time.Minute
+
+// Claims are the JWT claims issued to a logged-in user.
+type Claims struct {
+	Role    string `json:"role"`
+	Version int    `json:"ver"` // Must match User.TokenVersion
+	jwt.StandardClaims
+}
+
+// userRole returns the role of user.
+func userRole(user models.User) string {
+	if user.Role == "" {
+		return models.RoleCustomer // Accounts created before roles existed
+	}
+	return user.Role
+}
+
 // GenerateJWT generates a JWT token for a valid user
 func GenerateJWT(user models.User) (string, error) {
+	role := userRole(user)
+
 	// Create a new token object with the signing method and claims
-	claims := &jwt.StandardClaims{
-		Subject:   user.ID.Hex(), // User ID as the subject
-		Issuer:    "OldSouqsApp", // You can add an app name here
-		ExpiresAt: time.Now().Add(24 * time.Hour).Unix(), // Token expires in 24 hours
+	now := time.Now()
+	claims := &Claims{
+		Role:    role,
+		Version: user.TokenVersion,
+		StandardClaims: jwt.StandardClaims{
+			Id:        primitive.NewObjectID().Hex(), // Token ID, used to revoke it on logout
+			Subject:   user.ID.Hex(),                 // User ID as the subject
+			Issuer:    "OldSouqsApp",                 // You can add an app name here
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
 	}
 
 	// Create the token with the specified claims and signing method
@@ -38,28 +69,149 @@ func GenerateJWT(user models.User) (string, error) {
 	return signedToken, nil
 }
 
-func AuthMiddleware(next http.Handler) http.Handler {
-    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        tokenStr := r.Header.Get("Authorization")
-        if tokenStr == "" {
-            http.Error(w, "Authorization header missing", http.StatusUnauthorized)
-            return
-        }
-
-        tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
-
-        claims := &jwt.StandardClaims{}
-        token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-            return jwtKey, nil
-        })
-
-        if err != nil || !token.Valid {
-            http.Error(w, "Invalid token", http.StatusUnauthorized)
-            return
-        }
-
-        // Token is valid, set context for later use
-        ctx := context.WithValue(r.Context(), "userID", claims.Subject)
-        next.ServeHTTP(w, r.WithContext(ctx))
-    })
+// AuthMiddleware validates the bearer token and rejects tokens that were
+// revoked, either individually on logout or for the whole account through
+// the user's token version. The role is taken from the user as stored, not
+// from the token, so a role change applies right away.
+func AuthMiddleware(db *mongo.Database) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := r.Header.Get("Authorization")
+			if tokenStr == "" {
+				http.Error(w, "Authorization header missing", http.StatusUnauthorized)
+				return
+			}
+
+			tokenStr = strings.TrimPrefix(tokenStr, "Bearer ")
+
+			claims := &Claims{}
+			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+				return jwtKey, nil
+			})
+
+			if err != nil || !token.Valid {
+				http.Error(w, "Invalid token", http.StatusUnauthorized)
+				return
+			}
+
+			user, revoked, err := tokenUser(r.Context(), db, claims)
+			if err != nil {
+				log.Println("Error checking token revocation:", err)
+				http.Error(w, "Could not verify token", http.StatusInternalServerError)
+				return
+			} else if revoked {
+				http.Error(w, "Token revoked", http.StatusUnauthorized)
+				return
+			}
+			claims.Role = userRole(user)
+
+			// Token is valid, set context for later use
+			next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// OptionalAuthMiddleware is AuthMiddleware for public routes that show more
+// to some callers: a valid token sets the same context values, while a
+// missing, invalid or revoked one lets the request through anonymously.
+func OptionalAuthMiddleware(db *mongo.Database) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if tokenStr == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			claims := &Claims{}
+			token, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+				return jwtKey, nil
+			})
+			if err != nil || !token.Valid {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			user, revoked, err := tokenUser(r.Context(), db, claims)
+			if err != nil || revoked {
+				if err != nil {
+					log.Println("Error checking token revocation:", err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			claims.Role = userRole(user)
+
+			next.ServeHTTP(w, r.WithContext(withClaims(r.Context(), claims)))
+		})
+	}
+}
+
+// withClaims stores the caller's ID, role and claims on ctx.
+func withClaims(ctx context.Context, claims *Claims) context.Context {
+	ctx = context.WithValue(ctx, "userID", claims.Subject)
+	ctx = context.WithValue(ctx, "role", claims.Role)
+	return context.WithValue(ctx, "claims", claims)
+}
+
+// tokenUser loads the user behind claims and reports whether the token is
+// revoked: the account was deleted, had all its sessions ended, or this
+// particular token was logged out.
+func tokenUser(ctx context.Context, db *mongo.Database, claims *Claims) (models.User, bool, error) {
+	var user models.User
+	userID, err := primitive.ObjectIDFromHex(claims.Subject)
+	if err != nil {
+		return user, true, nil
+	}
+
+	err = db.Collection("users").FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return user, true, nil
+	} else if err != nil {
+		return user, false, err
+	}
+	if user.TokenVersion != claims.Version {
+		return user, true, nil
+	}
+
+	count, err := db.Collection("revoked_tokens").CountDocuments(ctx, bson.M{"_id": claims.Id})
+	if err != nil {
+		return user, false, err
+	}
+	return user, count > 0, nil
+}
+
+// RequireRole only lets a request through when the role set by
+// AuthMiddleware is one of roles. It must be wrapped by AuthMiddleware.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role := RoleFromContext(r.Context())
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
+
+// UserIDFromContext returns the user ID that AuthMiddleware stored on ctx.
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value("userID").(string)
+	return userID
+}
+
+// ClaimsFromContext returns the token claims that AuthMiddleware stored on ctx.
+func ClaimsFromContext(ctx context.Context) *Claims {
+	claims, _ := ctx.Value("claims").(*Claims)
+	return claims
+}
+
+// RoleFromContext returns the role that AuthMiddleware stored on ctx.
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value("role").(string)
+	return role
 }
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Mailer sends transactional emails such as password resets and
+// verification links.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// LogMailer is a Mailer for local testing. It appends every message to a
+// file, or writes it to the log when no file is configured.
+type LogMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewLogMailer returns a LogMailer writing to path, or to the log if path is empty.
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{Path: path}
+}
+
+// Send records the message instead of delivering it.
+func (m *LogMailer) Send(to, subject, body string) error {
+	message := fmt.Sprintf("Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), to, subject, body)
+
+	if m.Path == "" {
+		log.Print("Outgoing email:\n" + message)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open mail log %s: %w", m.Path, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(message); err != nil {
+		return fmt.Errorf("failed to write mail log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApplyMergePatch(t *testing.T) {
+	// The examples of RFC 7396, appendix A, followed by product patches
+	tests := []struct {
+		doc   string
+		patch string
+		want  string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		{`{"title":"Lamp","stock":4,"tag":["old"]}`, `{"stock":0}`, `{"title":"Lamp","stock":0,"tag":["old"]}`},
+		{`{"title":"Lamp","description":"Brass"}`, `{"description":""}`, `{"title":"Lamp","description":""}`},
+		{`{"title":"Lamp","tag":["old","brass"]}`, `{"tag":[]}`, `{"title":"Lamp","tag":[]}`},
+	}
+
+	for _, tt := range tests {
+		got, err := ApplyMergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("ApplyMergePatch(%s, %s): %v", tt.doc, tt.patch, err)
+			continue
+		}
+
+		var gotValue, wantValue interface{}
+		if err := json.Unmarshal(got, &gotValue); err != nil {
+			t.Fatalf("ApplyMergePatch(%s, %s) returned invalid JSON %s", tt.doc, tt.patch, got)
+		}
+		json.Unmarshal([]byte(tt.want), &wantValue)
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("ApplyMergePatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestApplyMergePatchInvalid(t *testing.T) {
+	if _, err := ApplyMergePatch([]byte(`{"a":`), []byte(`{}`)); err == nil {
+		t.Errorf("invalid document: want an error")
+	}
+	if _, err := ApplyMergePatch([]byte(`{}`), []byte(`{"a":`)); err == nil {
+		t.Errorf("invalid patch: want an error")
+	}
+}